@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 
@@ -12,57 +13,255 @@ import (
 	"github.com/gocolly/colly"
 )
 
+const (
+	DISCOVERY_WORKERS = 4
+)
+
 var (
 	ERR_ALREADY_GENERATED = errors.New("leaderboard tracks already generated")
 )
 
-func GenerateTimingLeaderboards() error {
-	cfg := config.GetConfig()
+// LeaderboardChanges lists the stages that appeared or disappeared since the last discovery.
+type LeaderboardChanges struct {
+	Added   []models.StageID
+	Removed []models.StageID
+}
+
+// DiscoveryError is reported for a region whose tracks or stages could not be crawled.
+type DiscoveryError struct {
+	Region string
+	Err    error
+}
+
+func (e *DiscoveryError) Error() string {
+	return fmt.Sprintf("cannot discover %s leaderboard: %v", e.Region, e.Err)
+}
+
+func (e *DiscoveryError) Unwrap() error {
+	return e.Err
+}
 
+// GenerateTimingLeaderboards discovers every leaderboard of the profile in use on its first run. Regions
+// that failed are reported in the returned error while the rest is still saved.
+func GenerateTimingLeaderboards(cfg *config.Config) error {
 	if cfg.Leaderboards != nil {
 		return ERR_ALREADY_GENERATED
 	}
 
-	leaderboards, err := getLeaderboardsName()
-	if err != nil {
+	leaderboards, discoverErr := discover(cfg.KBTBaseUrl)
+	if leaderboards == nil {
+		return discoverErr
+	}
+
+	cfg.Leaderboards = leaderboards
+	if err := cfg.Save(); err != nil {
 		return err
 	}
 
-	if cfg.Leaderboards == nil {
-		cfg.Leaderboards = make(models.Leaderboards)
+	return discoverErr
+}
+
+// RefreshTimingLeaderboards crawls the server of the profile in use again and merges newly found tracks and
+// stages into the config. Regions that failed to load keep their previous tracks, their errors are returned
+// along with the changes.
+func RefreshTimingLeaderboards(cfg *config.Config) (*LeaderboardChanges, error) {
+	leaderboards, discoverErr := discover(cfg.KBTBaseUrl)
+	if leaderboards == nil {
+		return nil, discoverErr
+	}
+
+	var discoveryErr *DiscoveryError
+	for _, err := range unjoin(discoverErr) {
+		if errors.As(err, &discoveryErr) {
+			if old, exists := cfg.Leaderboards[discoveryErr.Region]; exists {
+				leaderboards[discoveryErr.Region] = old
+			}
+		}
+	}
+
+	changes := &LeaderboardChanges{}
+	prev, curr := stageIDs(cfg.Leaderboards), stageIDs(leaderboards)
+	for id := range curr {
+		if !prev[id] {
+			changes.Added = append(changes.Added, id)
+		}
+	}
+	for id := range prev {
+		if !curr[id] {
+			changes.Removed = append(changes.Removed, id)
+		}
+	}
+	sortStageIDs(changes.Added)
+	sortStageIDs(changes.Removed)
+
+	cfg.Leaderboards = leaderboards
+	if err := cfg.Save(); err != nil {
+		return nil, err
+	}
+
+	return changes, discoverErr
+}
+
+type trackJob struct {
+	region string
+	url    string
+	track  string
+}
+
+type trackResult struct {
+	trackJob
+	stages []models.Stage
+	err    error
+}
+
+type regionResult struct {
+	region string
+	tracks []string
+	err    error
+}
+
+// discover crawls every leaderboard, its tracks and their stages with a bounded number of
+// concurrent requests. The leaderboards are nil only when the region list itself cannot be fetched.
+func discover(baseUrl string) (models.Leaderboards, error) {
+	names, err := getLeaderboardsName(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]models.Leaderboard, 0, len(names))
+	for _, leaderboard := range names {
+		regions = append(regions, leaderboard)
+	}
+
+	failed := make(map[string]error)
+
+	// first stage: list the tracks of every region
+	var jobs []trackJob
+	for r := range pool(regions, DISCOVERY_WORKERS, func(l models.Leaderboard) regionResult {
+		tracks, err := getTracks(l.Url)
+		return regionResult{region: l.Region, tracks: tracks, err: err}
+	}) {
+		if r.err != nil {
+			failed[r.region] = r.err
+			continue
+		}
+		for _, track := range r.tracks {
+			jobs = append(jobs, trackJob{region: r.region, url: names[r.region].Url, track: track})
+		}
 	}
 
+	// second stage: list the stages of every track
+	tracks := make(map[string][]models.Track)
+	for r := range pool(jobs, DISCOVERY_WORKERS, func(j trackJob) trackResult {
+		stages, err := getStages(j.url, j.track)
+		if err != nil {
+			return trackResult{trackJob: j, err: err}
+		}
+		result := trackResult{trackJob: j}
+		for _, stage := range stages {
+			stageUrl, err := buildStageUrl(j.url, j.track, stage)
+			if err != nil {
+				continue
+			}
+			result.stages = append(result.stages, models.Stage{
+				Name: stage,
+				Url:  stageUrl,
+			})
+		}
+		return result
+	}) {
+		if r.err != nil {
+			if _, exists := failed[r.region]; !exists {
+				failed[r.region] = fmt.Errorf("track %s: %v", r.track, r.err)
+			}
+			continue
+		}
+		tracks[r.region] = append(tracks[r.region], models.Track{
+			Name:   r.track,
+			Stages: r.stages,
+		})
+	}
+
+	leaderboards := make(models.Leaderboards, len(names))
+	for region, leaderboard := range names {
+		leaderboard.Tracks = tracks[region]
+		slices.SortFunc(leaderboard.Tracks, func(a, b models.Track) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		leaderboards[region] = leaderboard
+	}
+
+	var errs []error
+	for region, err := range failed {
+		errs = append(errs, &DiscoveryError{Region: region, Err: err})
+	}
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+
+	return leaderboards, errors.Join(errs...)
+}
+
+// pool runs fn over every job with at most n workers and streams the results back.
+func pool[J, R any](jobs []J, n int, fn func(J) R) <-chan R {
+	jobCh := make(chan J)
+	resCh := make(chan R)
+
+	go func() {
+		defer close(jobCh)
+		for _, j := range jobs {
+			jobCh <- j
+		}
+	}()
+
 	var wg sync.WaitGroup
-	for region, leaderboard := range *leaderboards {
+	for range min(n, len(jobs)) {
 		wg.Add(1)
-		cfg.Leaderboards[region] = leaderboard
-		go func(u, r string) {
+		go func() {
 			defer wg.Done()
-			tracks, err := getTracks(u)
-			if err != nil {
-				return
+			for j := range jobCh {
+				resCh <- fn(j)
 			}
-			for _, track := range tracks {
-				entry, exists := cfg.Leaderboards[r]
-				if !exists {
-					continue
-				}
-				entry.Tracks = append(entry.Tracks, *track)
-				cfg.Leaderboards[region] = entry
-			}
-		}(leaderboard.Url, region)
+		}()
 	}
-	wg.Wait()
 
-	if err := cfg.Save(); err != nil {
-		return err
+	go func() {
+		wg.Wait()
+		close(resCh)
+	}()
+
+	return resCh
+}
+
+func unjoin(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
 	}
+	return []error{err}
+}
+
+func stageIDs(leaderboards models.Leaderboards) map[models.StageID]bool {
+	ids := make(map[models.StageID]bool)
+	for region, leaderboard := range leaderboards {
+		for _, track := range leaderboard.Tracks {
+			for _, stage := range track.Stages {
+				ids[models.StageID{Region: region, Track: track.Name, Stage: stage.Name}] = true
+			}
+		}
+	}
+	return ids
+}
 
-	return nil
+func sortStageIDs(ids []models.StageID) {
+	slices.SortFunc(ids, func(a, b models.StageID) int {
+		return strings.Compare(a.String(), b.String())
+	})
 }
 
-func getLeaderboardsName() (*map[string]models.Leaderboard, error) {
-	cfg := config.GetConfig()
+func getLeaderboardsName(baseUrl string) (map[string]models.Leaderboard, error) {
 	leaderboard := make(map[string]models.Leaderboard)
 	c := colly.NewCollector()
 
@@ -80,7 +279,7 @@ func getLeaderboardsName() (*map[string]models.Leaderboard, error) {
 			elem := colly.NewHTMLElementFromSelectionNode(h.Response, next, node, 0)
 			for _, attr := range node.Attr {
 				if attr.Key == "href" {
-					u, err := url.Parse(fmt.Sprintf("%v%v", cfg.KBTBaseUrl, attr.Val))
+					u, err := url.Parse(fmt.Sprintf("%v%v", baseUrl, attr.Val))
 					if err != nil {
 						continue
 					}
@@ -99,23 +298,23 @@ func getLeaderboardsName() (*map[string]models.Leaderboard, error) {
 		}
 	})
 
-	err := c.Visit(cfg.KBTBaseUrl)
+	err := c.Visit(baseUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	return &leaderboard, nil
+	return leaderboard, nil
 }
 
-func getTracks(regionUrl string) (map[string]*models.Track, error) {
-	tracks := make(map[string]*models.Track)
+func getTracks(regionUrl string) ([]string, error) {
+	var tracks []string
 
 	c := colly.NewCollector()
 
 	c.OnHTML("select[name='track']", func(h *colly.HTMLElement) {
 		for _, t := range strings.Fields(h.Text) {
-			tracks[t] = &models.Track{
-				Name: t,
+			if !slices.Contains(tracks, t) {
+				tracks = append(tracks, t)
 			}
 		}
 	})
@@ -124,31 +323,6 @@ func getTracks(regionUrl string) (map[string]*models.Track, error) {
 		return nil, err
 	}
 
-	var wg sync.WaitGroup
-
-	for key, track := range tracks {
-		wg.Add(1)
-		go func(track models.Track, key, r string) {
-			defer wg.Done()
-			stages, err := getStages(r, track.Name)
-			if err != nil {
-				return
-			}
-			for _, stage := range stages {
-				stageUrl, err := buildStageUrl(r, track.Name, stage)
-				if err != nil {
-					continue
-				}
-				track.Stages = append(track.Stages, models.Stage{
-					Name: stage,
-					Url:  stageUrl,
-				})
-			}
-			tracks[key] = &track
-		}(*track, key, regionUrl)
-	}
-	wg.Wait()
-
 	return tracks, nil
 }
 
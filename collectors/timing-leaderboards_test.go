@@ -0,0 +1,132 @@
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+)
+
+func fakeKBTServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<html><body>
+				<a id="Timing-navbar-dropdown">Timing</a>
+				<div>
+					<a href="/timing?leaderboard=gunma">Gunma</a>
+					<a href="/timing?leaderboard=kanagawa">Kanagawa</a>
+					<a href="/timing?leaderboard=broken">Broken</a>
+				</div>
+			</body></html>`)
+		case q.Get("leaderboard") == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case q.Has("track"):
+			fmt.Fprint(w, `<html><body><select name="stage">
+				<option>downhill</option>
+				<option>uphill</option>
+			</select></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body><select name="track">
+				<option>akina</option>
+				<option>usui</option>
+				<option>myogi</option>
+			</select></body></html>`)
+		}
+	}))
+}
+
+func TestDiscover(t *testing.T) {
+	server := fakeKBTServer()
+	defer server.Close()
+
+	leaderboards, err := discover(server.URL)
+	if leaderboards == nil {
+		t.Fatalf("expected leaderboards to be discovered: %v\n", err)
+	}
+
+	var discoveryErr *DiscoveryError
+	if !errors.As(err, &discoveryErr) || discoveryErr.Region != "broken" {
+		t.Fatalf("expected the broken region to be reported, got %v\n", err)
+	}
+
+	for _, region := range []string{"gunma", "kanagawa"} {
+		l, exists := leaderboards[region]
+		if !exists {
+			t.Fatalf("missing %s leaderboard\n", region)
+		}
+		if len(l.Tracks) != 3 {
+			t.Fatalf("expected 3 tracks in %s, got %d\n", region, len(l.Tracks))
+		}
+		for _, track := range l.Tracks {
+			if len(track.Stages) != 2 {
+				t.Fatalf("expected 2 stages in %s/%s, got %d\n", region, track.Name, len(track.Stages))
+			}
+		}
+	}
+
+	if len(leaderboards["broken"].Tracks) != 0 {
+		t.Fatal("broken leaderboard should not have any tracks")
+	}
+}
+
+func TestRefreshTimingLeaderboards(t *testing.T) {
+	server := fakeKBTServer()
+	defer server.Close()
+
+	cfg, err := config.Load(t.TempDir(), "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	cfg.KBTBaseUrl = server.URL
+	cfg.Leaderboards = models.Leaderboards{
+		"gunma": {Region: "gunma", Tracks: []models.Track{
+			{Name: "akina", Stages: []models.Stage{{Name: "downhill"}}},
+			{Name: "haruna", Stages: []models.Stage{{Name: "downhill"}}},
+		}},
+		"broken": {Region: "broken", Tracks: []models.Track{
+			{Name: "irohazaka", Stages: []models.Stage{{Name: "downhill"}}},
+		}},
+	}
+
+	changes, err := RefreshTimingLeaderboards(cfg)
+	if changes == nil {
+		t.Fatalf("expected the leaderboards to be refreshed: %v\n", err)
+	}
+	var discoveryErr *DiscoveryError
+	if !errors.As(err, &discoveryErr) || discoveryErr.Region != "broken" {
+		t.Fatalf("expected the broken region to be reported, got %v\n", err)
+	}
+
+	var added []models.StageID
+	for _, region := range []string{"gunma", "kanagawa"} {
+		for _, track := range []string{"akina", "usui", "myogi"} {
+			for _, stage := range []string{"downhill", "uphill"} {
+				id := models.StageID{Region: region, Track: track, Stage: stage}
+				if id != (models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}) {
+					added = append(added, id)
+				}
+			}
+		}
+	}
+	sortStageIDs(added)
+	if !slices.Equal(changes.Added, added) {
+		t.Fatalf("expected %v to be added, got %v\n", added, changes.Added)
+	}
+	removed := []models.StageID{{Region: "gunma", Track: "haruna", Stage: "downhill"}}
+	if !slices.Equal(changes.Removed, removed) {
+		t.Fatalf("expected %v to be removed, got %v\n", removed, changes.Removed)
+	}
+
+	// the region that failed keeps what was known about it
+	broken := cfg.Leaderboards["broken"].Tracks
+	if len(broken) != 1 || broken[0].Name != "irohazaka" {
+		t.Fatalf("broken region should keep its previous tracks, got %v\n", broken)
+	}
+}
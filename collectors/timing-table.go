@@ -1,10 +1,15 @@
 package collectors
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,31 +20,93 @@ import (
 )
 
 type TimingTable struct {
-	Store        *store.Store
+	Store        store.Store
 	Cfg          *config.Config
 	CurrentMonth bool
+	// BeforeCommit is called with every result before the new records are stored,
+	// returning an error keeps the previous records in place.
+	BeforeCommit func(r TimingResult) error
 	wg           sync.WaitGroup
 }
 
 type TimingResult struct {
-	Prev  []models.Record
-	Curr  []models.Record
-	stage string
-	err   error
+	Prev []models.Record
+	Curr []models.Record
+	ID   models.StageID
+	Url  string
+	err  error
 }
 
-func (t *TimingTable) stageKey(trackName, stage string) string {
-	if t.CurrentMonth {
-		year, month, _ := time.Now().Date()
-		return fmt.Sprintf("%d-%d_%s-%s", year, month, trackName, stage)
+func (t *TimingTable) stageKey(id models.StageID) string {
+	return StageKey(id, t.CurrentMonth, time.Now())
+}
+
+// StageKey is the store key holding the records of a stage, monthly records are keyed by the month of at.
+// The region is part of it since leaderboards can share a track.
+func StageKey(id models.StageID, currentMonth bool, at time.Time) string {
+	if currentMonth {
+		return fmt.Sprintf("%s%s/%s-%s", MonthKeyPrefix(at), id.Region, id.Track, id.Stage)
 	} else {
-		return fmt.Sprintf("%s-%s", trackName, stage)
+		return fmt.Sprintf("%s/%s-%s", id.Region, id.Track, id.Stage)
+	}
+}
+
+// MigrateStageKeys moves the snapshots stored under keys without a region to the keys of their stage,
+// every version is kept. A track found in several leaderboards cannot be told apart, its snapshots are
+// left where they are and those leaderboards start over.
+func MigrateStageKeys(s store.Store, leaderboards models.Leaderboards) (int, error) {
+	regions := make(map[string][]string)
+	for region, l := range leaderboards {
+		for _, track := range l.Tracks {
+			for _, stage := range track.Stages {
+				old := fmt.Sprintf("%s-%s", track.Name, stage.Name)
+				if !slices.Contains(regions[old], region) {
+					regions[old] = append(regions[old], region)
+				}
+			}
+		}
+	}
+
+	var moved int
+	for _, key := range s.Keys() {
+		month := monthPrefix.FindString(key)
+		old := strings.TrimPrefix(key, month)
+		found := regions[old]
+		if len(found) != 1 {
+			continue
+		}
+
+		records, err := s.History(key)
+		if err != nil {
+			return moved, fmt.Errorf("cannot migrate %s: %v", key, err)
+		}
+		migrated := month + found[0] + "/" + old
+		for _, r := range records {
+			if err := s.Put(store.Record{Timestamp: r.Timestamp, Key: []byte(migrated), Value: r.Value}); err != nil {
+				return moved, fmt.Errorf("cannot migrate %s: %v", key, err)
+			}
+		}
+		if err := s.Delete(key); err != nil {
+			return moved, fmt.Errorf("cannot migrate %s: %v", key, err)
+		}
+		moved++
 	}
+	return moved, nil
 }
 
-func (t *TimingTable) Extract(l string) (map[string]TimingResult, error) {
+var monthPrefix = regexp.MustCompile(`^\d{4}-\d{1,2}_`)
+
+// MonthKeyPrefix is shared by the keys of every monthly leaderboard of the month of at.
+func MonthKeyPrefix(at time.Time) string {
+	year, month, _ := at.Date()
+	return fmt.Sprintf("%d-%d_", year, month)
+}
+
+// Extract collects every stage of the leaderboard and returns once all of them are done, stages still
+// pending when ctx is done are skipped without touching the store.
+func (t *TimingTable) Extract(ctx context.Context, l string) (map[models.StageID]TimingResult, error) {
 	tracks := make(map[string][]models.Stage)
-	result := make(map[string]TimingResult)
+	result := make(map[models.StageID]TimingResult)
 	leaderboard, exists := t.Cfg.Leaderboards[l]
 	if !exists {
 		return nil, fmt.Errorf("cannot find leaderboard: %s", l)
@@ -55,7 +122,7 @@ func (t *TimingTable) Extract(l string) (map[string]TimingResult, error) {
 	t.wg.Add(stages)
 	for trackName, stages := range tracks {
 		for _, stage := range stages {
-			go t.processRecords(trackName, stage, resChan)
+			go t.processRecords(ctx, models.StageID{Region: l, Track: trackName, Stage: stage.Name}, stage, resChan)
 		}
 	}
 
@@ -69,19 +136,21 @@ func (t *TimingTable) Extract(l string) (map[string]TimingResult, error) {
 			fmt.Println(r.err)
 			continue
 		}
-		result[r.stage] = TimingResult{
-			Prev: r.Prev,
-			Curr: r.Curr,
-		}
+		result[r.ID] = r
 	}
 
 	return result, nil
 }
 
-func (t *TimingTable) processRecords(trackName string, stage models.Stage, ch chan<- TimingResult) {
+func (t *TimingTable) processRecords(ctx context.Context, id models.StageID, stage models.Stage, ch chan<- TimingResult) {
 	defer t.wg.Done()
 
-	prev, err := t.prevTimingRecords(trackName, stage.Name)
+	if err := ctx.Err(); err != nil {
+		ch <- TimingResult{err: fmt.Errorf("%s: %v", id, err)}
+		return
+	}
+
+	prev, err := t.prevTimingRecords(id)
 	if err != nil {
 		if err != store.ERR_KEY_NOT_FOUND {
 			ch <- TimingResult{err: err}
@@ -89,27 +158,45 @@ func (t *TimingTable) processRecords(trackName string, stage models.Stage, ch ch
 		}
 	}
 
-	curr, err := t.getRecords(stage)
+	curr, err := t.getRecords(ctx, stage)
 	if err != nil {
 		ch <- TimingResult{err: err}
 		return
 	}
-
-	if err := t.updateTimingRecords(curr, trackName, stage.Name); err != nil {
-		ch <- TimingResult{err: err}
+	// the caller gave up on the results, nothing is announced or stored
+	if err := ctx.Err(); err != nil {
+		ch <- TimingResult{err: fmt.Errorf("%s: %v", id, err)}
 		return
 	}
 
-	ch <- TimingResult{
-		stage: stage.Name,
-		Prev:  prev,
-		Curr:  curr,
+	result := TimingResult{
+		Prev: prev,
+		Curr: curr,
+		ID:   id,
+		Url:  stage.Url,
+	}
+
+	if t.BeforeCommit != nil {
+		if err := t.BeforeCommit(result); err != nil {
+			ch <- TimingResult{err: err}
+			return
+		}
 	}
+
+	// only distinct snapshots are stored so the history stays meaningful
+	if !slices.Equal(prev, curr) {
+		if err := t.updateTimingRecords(curr, id); err != nil {
+			ch <- TimingResult{err: err}
+			return
+		}
+	}
+
+	ch <- result
 }
 
-func (t *TimingTable) prevTimingRecords(trackName, stage string) ([]models.Record, error) {
+func (t *TimingTable) prevTimingRecords(id models.StageID) ([]models.Record, error) {
 	var records []models.Record
-	r, err := t.Store.Get(t.stageKey(trackName, stage))
+	r, err := t.Store.Get(t.stageKey(id))
 	if err != nil {
 		return records, err
 	}
@@ -119,14 +206,14 @@ func (t *TimingTable) prevTimingRecords(trackName, stage string) ([]models.Recor
 	return records, nil
 }
 
-func (t *TimingTable) updateTimingRecords(records []models.Record, trackName, stage string) error {
+func (t *TimingTable) updateTimingRecords(records []models.Record, id models.StageID) error {
 	jsonRecords, err := json.Marshal(records)
 	if err != nil {
 		return fmt.Errorf("error while marshaling json: %v", err)
 	}
 	err = t.Store.Put(store.Record{
-		Timestamp: uint32(time.Now().Unix()),
-		Key:       []byte(t.stageKey(trackName, stage)),
+		Timestamp: time.Now().UnixNano(),
+		Key:       []byte(t.stageKey(id)),
 		Value:     jsonRecords,
 	})
 	if err != nil {
@@ -135,9 +222,10 @@ func (t *TimingTable) updateTimingRecords(records []models.Record, trackName, st
 	return nil
 }
 
-func (t *TimingTable) getRecords(stage models.Stage) ([]models.Record, error) {
+func (t *TimingTable) getRecords(ctx context.Context, stage models.Stage) ([]models.Record, error) {
 	records := []models.Record{}
 	c := colly.NewCollector()
+	c.WithTransport(contextTransport{ctx: ctx, next: http.DefaultTransport})
 
 	c.OnHTML("table", func(h *colly.HTMLElement) {
 		h.ForEach("tbody > tr", func(i int, h *colly.HTMLElement) {
@@ -172,3 +260,13 @@ func (t *TimingTable) getRecords(stage models.Stage) ([]models.Record, error) {
 
 	return records, nil
 }
+
+// contextTransport cancels the requests of a collector along with ctx, colly has no context of its own.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(r.WithContext(t.ctx))
+}
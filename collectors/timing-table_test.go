@@ -0,0 +1,126 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/store"
+)
+
+func TestTimingTableExtract(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><table><tbody>
+			<tr><td>1</td><td>2025-03-01</td><td>takumi</td><td>AE86</td><td>2:31.000</td></tr>
+			<tr><td>2</td><td>2025-03-02</td><td>keisuke</td><td>FD3S</td><td>2:32.000</td></tr>
+		</tbody></table></body></html>`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{Profile: &config.Profile{
+		Leaderboards: models.Leaderboards{
+			"gunma": {
+				Region: "gunma",
+				Tracks: []models.Track{
+					{Name: "akina", Stages: []models.Stage{{Name: "downhill", Url: server.URL + "/timing?track=akina&stage=downhill"}}},
+				},
+			},
+			"kanagawa": {
+				Region: "kanagawa",
+				Tracks: []models.Track{
+					{Name: "akina", Stages: []models.Stage{{Name: "downhill", Url: server.URL + "/timing?track=akina&stage=downhill"}}},
+				},
+			},
+		},
+	}}
+
+	s := store.NewMemoryStore()
+	var mu sync.Mutex
+	var committed []TimingResult
+	timing := &TimingTable{
+		Store: s,
+		Cfg:   cfg,
+		BeforeCommit: func(r TimingResult) error {
+			mu.Lock()
+			defer mu.Unlock()
+			committed = append(committed, r)
+			return nil
+		},
+	}
+
+	for range 2 {
+		if _, err := timing.Extract(context.Background(), "gunma"); err != nil {
+			t.Fatalf("error while extracting leaderboard: %v\n", err)
+		}
+	}
+
+	if len(committed) != 2 || len(committed[0].Prev) != 0 || len(committed[1].Prev) != 2 {
+		t.Fatalf("expected the second run to see the records of the first one, got %+v\n", committed)
+	}
+	if id := committed[0].ID; id != (models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}) {
+		t.Fatalf("unexpected stage id %v\n", id)
+	}
+
+	// an unchanged leaderboard is not stored again
+	records, err := s.History(StageKey(models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, false, time.Now()))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected a single snapshot, got %d: %v\n", len(records), err)
+	}
+
+	// another leaderboard with the same track keeps its own snapshots
+	if _, err := timing.Extract(context.Background(), "kanagawa"); err != nil {
+		t.Fatalf("error while extracting leaderboard: %v\n", err)
+	}
+	if len(committed) != 3 || len(committed[2].Prev) != 0 {
+		t.Fatalf("kanagawa should not see the records of gunma, got %+v\n", committed)
+	}
+
+	// nothing is announced or stored once the caller gave up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := timing.Extract(ctx, "gunma"); err != nil {
+		t.Fatalf("error while extracting leaderboard: %v\n", err)
+	}
+	if len(committed) != 3 {
+		t.Fatalf("a cancelled extraction should not commit, got %d commits\n", len(committed))
+	}
+}
+
+func TestMigrateStageKeys(t *testing.T) {
+	leaderboards := models.Leaderboards{
+		"gunma": {Region: "gunma", Tracks: []models.Track{
+			{Name: "akina", Stages: []models.Stage{{Name: "downhill"}}},
+			{Name: "usui", Stages: []models.Stage{{Name: "uphill"}}},
+		}},
+		"kanagawa": {Region: "kanagawa", Tracks: []models.Track{
+			{Name: "akina", Stages: []models.Stage{{Name: "downhill"}}},
+		}},
+	}
+
+	s := store.NewMemoryStore()
+	for i, key := range []string{"usui-uphill", "usui-uphill", "2025-3_usui-uphill", "akina-downhill", "__outbox"} {
+		if err := s.Put(store.Record{Timestamp: int64(i), Key: []byte(key), Value: []byte("[]")}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+
+	moved, err := MigrateStageKeys(s, leaderboards)
+	if err != nil || moved != 2 {
+		t.Fatalf("expected 2 keys to be migrated, got %d: %v\n", moved, err)
+	}
+	expected := []string{"2025-3_gunma/usui-uphill", "__outbox", "akina-downhill", "gunma/usui-uphill"}
+	if keys := s.Keys(); !slices.Equal(keys, expected) {
+		t.Fatalf("expected keys %v, got %v\n", expected, keys)
+	}
+	records, err := s.History("gunma/usui-uphill")
+	if err != nil || len(records) != 2 || records[0].Timestamp != 0 || records[1].Timestamp != 1 {
+		t.Fatalf("every version should be migrated in order, got %d: %v\n", len(records), err)
+	}
+}
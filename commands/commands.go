@@ -2,14 +2,34 @@ package commands
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/dimfu/kaido/commands/database"
 	"github.com/dimfu/kaido/commands/leaderboard"
+	"github.com/dimfu/kaido/commands/settings"
+	"github.com/dimfu/kaido/commands/setup"
+	"github.com/dimfu/kaido/commands/watch"
+	"github.com/dimfu/kaido/config"
 	"github.com/dimfu/kaido/discord"
 	"github.com/urfave/cli/v3"
 )
 
 func Commands() []*cli.Command {
 	return []*cli.Command{
+		{
+			Name:  "init",
+			Usage: "create or update the config, settings given as flags are saved to it",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "non_interactive",
+					Usage:   "fail instead of asking for missing values",
+					Sources: cli.EnvVars("KAIDO_NON_INTERACTIVE"),
+				},
+			},
+			Action: setup.Init,
+		},
 		{
 			Name:    "run",
 			Aliases: []string{"r"},
@@ -27,16 +47,190 @@ func Commands() []*cli.Command {
 					Aliases: []string{"c"},
 				},
 			},
+			Before: discover,
 			Action: leaderboard.Extract,
 		},
+		{
+			Name:  "watch",
+			Usage: "keep polling leaderboards on a schedule until interrupted",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "leaderboard",
+					Value: "all",
+					Usage: "leaderboards to watch when no schedule is configured, default to all",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Value: time.Hour,
+					Usage: "how often all-time records are polled, 0 to disable",
+				},
+				&cli.DurationFlag{
+					Name:  "month_interval",
+					Value: 10 * time.Minute,
+					Usage: "how often current month records are polled, 0 to disable",
+				},
+			},
+			// profiles are discovered by the watcher so an unreachable server does not stop the others
+			Before: requireConfig,
+			Action: watch.Watch,
+		},
 		{
 			Name:   "leaderboards",
 			Usage:  "See all available leaderboards",
+			Before: discover,
 			Action: leaderboard.List,
 		},
 		{
-			Name:  "webhook",
-			Usage: "options for webhook",
+			Name:  "history",
+			Usage: "show who held the record on a stage at a given date",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "region",
+					Usage: "leaderboard of the track eg; gunma, only needed when several leaderboards have the track",
+				},
+				&cli.StringFlag{
+					Name:     "track",
+					Usage:    "track name eg; akina",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "stage",
+					Usage:    "stage name eg; downhill",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "at",
+					Usage: "date to look at as YYYY-MM-DD, default to today",
+				},
+				&cli.BoolFlag{
+					Name:    "current_month",
+					Usage:   "look at the monthly leaderboard of that date instead",
+					Aliases: []string{"c"},
+				},
+				&cli.BoolFlag{
+					Name:  "timeline",
+					Usage: "list every stored snapshot instead of a single date",
+				},
+			},
+			Before: requireConfig,
+			Action: leaderboard.History,
+		},
+		{
+			Name:   "refresh",
+			Usage:  "re-discover leaderboards, tracks and stages from the server",
+			Before: requireConfig,
+			Action: leaderboard.Refresh,
+		},
+		{
+			Name:  "store",
+			Usage: "maintenance of the record store",
+			Commands: []*cli.Command{
+				{
+					Name:   "verify",
+					Usage:  "scan the store file and report its integrity",
+					Action: database.Verify,
+				},
+				{
+					Name:   "compact",
+					Usage:  "rewrite the store without dropped records",
+					Action: database.Compact,
+				},
+				{
+					Name:      "keys",
+					Usage:     "list stored keys, eg; kaido store keys 2025-3_ for every stage of March 2025",
+					ArgsUsage: "[prefix]",
+					Action:    database.Keys,
+				},
+				{
+					Name:  "prune",
+					Usage: "delete monthly leaderboards older than a month",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "before",
+							Usage:    "first month to keep as YYYY-MM",
+							Required: true,
+						},
+					},
+					Action: database.Prune,
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "inspect and change the configuration",
+			Commands: []*cli.Command{
+				{
+					Name:  "show",
+					Usage: "print the config file",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "effective",
+							Usage: "print the settings in use after environment variables and flags, with where each one came from",
+						},
+						&cli.BoolFlag{
+							Name:  "reveal",
+							Usage: "do not mask webhook urls",
+						},
+					},
+					Action: settings.Show,
+				},
+				{
+					Name:      "get",
+					Usage:     "print the value of a setting",
+					ArgsUsage: "<setting>",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "reveal",
+							Usage: "do not mask webhook urls",
+						},
+					},
+					Action: settings.Get,
+				},
+				{
+					Name:      "set",
+					Usage:     "save a setting to the config, lists take one value per argument",
+					ArgsUsage: "<setting> <value>...",
+					Action:    settings.Set,
+				},
+				{
+					Name:      "unset",
+					Usage:     "remove a setting from the config so its default is used",
+					ArgsUsage: "<setting>",
+					Action:    settings.Unset,
+				},
+				{
+					Name:   "validate",
+					Usage:  "check the config for mistakes",
+					Action: settings.Validate,
+				},
+				{
+					Name:   "edit",
+					Usage:  "open the config in $VISUAL or $EDITOR, it is only saved when valid",
+					Action: settings.Edit,
+				},
+			},
+		},
+		{
+			Name:  "profile",
+			Usage: "list the server profiles or pick the default one",
+			Commands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list the profiles, the one in use is marked with a *",
+					Action: settings.Profiles,
+				},
+				{
+					Name:      "use",
+					Usage:     "use the profile when --profile is not given",
+					ArgsUsage: "<profile>",
+					Action:    settings.UseProfile,
+				},
+			},
+		},
+		{
+			Name:   "webhook",
+			Usage:  "options for webhook",
+			Before: requireConfig,
 			Commands: []*cli.Command{
 				{
 					Name:  "set",
@@ -53,3 +247,36 @@ func Commands() []*cli.Command {
 		},
 	}
 }
+
+// requireConfig stops commands that cannot do anything useful before `kaido init` or with a broken config.
+func requireConfig(ctx context.Context, c *cli.Command) (context.Context, error) {
+	cfg := config.GetConfig()
+	if !cfg.Loaded() {
+		return ctx, config.ERR_NOT_INITIALIZED
+	}
+	if !cfg.HasProfile(cfg.ActiveProfile()) {
+		return ctx, fmt.Errorf("unknown profile %s, known profiles are %s, create it with `kaido --profile %s init`",
+			cfg.ActiveProfile(), strings.Join(cfg.ProfileNames(), ", "), cfg.ActiveProfile())
+	}
+	return ctx, validate(cfg)
+}
+
+func validate(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config, fix it with `kaido config edit`:\n%v", err)
+	}
+	return nil
+}
+
+// discover crawls the leaderboards the first time a command needs them.
+func discover(ctx context.Context, c *cli.Command) (context.Context, error) {
+	if _, err := requireConfig(ctx, c); err != nil {
+		return ctx, err
+	}
+
+	if err := leaderboard.Discover(config.GetConfig()); err != nil {
+		return ctx, err
+	}
+	// leaderboard regions of the schedules can only be checked once they are discovered
+	return ctx, validate(config.GetConfig())
+}
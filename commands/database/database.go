@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/dimfu/kaido/collectors"
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/store"
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	// monthly leaderboards are keyed as YYYY-M_track-stage
+	monthKey = regexp.MustCompile(`^(\d{4}-\d{1,2})_`)
+)
+
+func Verify(ctx context.Context, c *cli.Command) error {
+	path, err := store.Path()
+	if err != nil {
+		return err
+	}
+
+	segments, err := store.Segments(path)
+	if err != nil {
+		return err
+	}
+	// stores written before segments existed are a single file until they are opened
+	if len(segments) == 0 {
+		segments = []string{path}
+	}
+
+	corrupt := false
+	for _, segment := range segments {
+		report, err := store.Verify(segment)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("File:    %s\n", report.Path)
+		fmt.Printf("Size:    %d bytes\n", report.Size)
+		fmt.Printf("Records: %d (%d keys)\n", report.Records, report.Keys)
+		if report.Legacy {
+			fmt.Println("Format:  unversioned, will be upgraded on next open")
+		} else {
+			fmt.Printf("Format:  version %d\n", report.Version)
+		}
+		if report.Corrupt > 0 {
+			fmt.Printf("Corrupt: %d records fail their checksum\n", report.Corrupt)
+		}
+		if report.Err != nil {
+			fmt.Printf("Corrupt: %d bytes unreadable from offset %d: %v\n", report.Size-report.Valid, report.Valid, report.Err)
+		}
+		corrupt = corrupt || !report.Ok()
+		fmt.Println()
+	}
+
+	if corrupt {
+		return errors.New("store is corrupt, corrupt records are skipped and a torn tail is truncated on next open")
+	}
+
+	fmt.Println("Store is healthy")
+	return nil
+}
+
+func Compact(ctx context.Context, c *cli.Command) error {
+	s, err := store.GetInstance(ctx)
+	if err != nil {
+		return err
+	}
+
+	compacter, ok := s.(store.Compacter)
+	if !ok {
+		return errors.New("the configured store backend cannot be compacted")
+	}
+
+	before := compacter.Size()
+	if err := compacter.Compact(); err != nil {
+		return fmt.Errorf("cannot compact store: %v", err)
+	}
+
+	fmt.Printf("Compacted store from %d to %d bytes\n", before, compacter.Size())
+	return nil
+}
+
+// Keys lists the stored keys, optionally only the ones starting with the first argument.
+func Keys(ctx context.Context, c *cli.Command) error {
+	s, err := open(ctx)
+	if err != nil {
+		return err
+	}
+
+	prefix := c.Args().First()
+	for r, err := range s.Scan(prefix) {
+		if err != nil {
+			return err
+		}
+		updated := time.Unix(0, r.Timestamp).Format(time.DateTime)
+		fmt.Printf("%s\t%s\t%d bytes\n", r.Key, updated, len(r.Value))
+	}
+	return nil
+}
+
+// Prune deletes the monthly leaderboards of every month before the given one.
+func Prune(ctx context.Context, c *cli.Command) error {
+	before, err := time.Parse("2006-01", c.String("before"))
+	if err != nil {
+		return fmt.Errorf("month must be YYYY-MM: %v", err)
+	}
+
+	s, err := open(ctx)
+	if err != nil {
+		return err
+	}
+
+	months := make(map[string]bool)
+	for _, key := range s.Keys() {
+		namespace, key := store.SplitNamespace(key)
+		match := monthKey.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		month, err := time.Parse("2006-1", match[1])
+		if err != nil || !month.Before(before) {
+			continue
+		}
+		months[store.NamespaceKey(namespace, collectors.MonthKeyPrefix(month))] = true
+	}
+
+	var deleted int
+	for prefix := range months {
+		n, err := s.DeletePrefix(prefix)
+		deleted += n
+		if err != nil {
+			return fmt.Errorf("cannot prune %s: %v", prefix, err)
+		}
+	}
+
+	fmt.Printf("Deleted %d monthly leaderboards before %s\n", deleted, before.Format("2006-01"))
+	return nil
+}
+
+// open is the whole store, or only the records of the profile picked with --profile.
+func open(ctx context.Context) (store.Store, error) {
+	s, err := store.GetInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg := config.GetConfig(); cfg.ExplicitProfile() {
+		return store.Namespace(s, cfg.Namespace), nil
+	}
+	return s, nil
+}
@@ -0,0 +1,188 @@
+package leaderboard
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/notify"
+)
+
+const (
+	DEFAULT_TOP_N = 10
+)
+
+var (
+	// rank gained/lost is left out by default since every new entry shifts everyone below it
+	defaultEvents = []notify.Kind{
+		notify.KindNewRecord,
+		notify.KindNewEntrant,
+		notify.KindPersonalBest,
+		notify.KindDroppedOff,
+		notify.KindTimeRemoved,
+	}
+)
+
+type diffOptions struct {
+	TopN         int
+	CurrentMonth bool
+}
+
+type lap struct {
+	record  models.Record
+	seconds float64
+}
+
+// diff compares two snapshots of the same stage leaderboard and returns everything that changed in the top N.
+func diff(id models.StageID, prev, curr []models.Record, opts diffOptions) ([]notify.Event, error) {
+	if len(prev) == 0 && len(curr) == 0 {
+		return nil, fmt.Errorf("Cannot find records in %s, skipping...", id)
+	}
+	if len(curr) == 0 {
+		return nil, fmt.Errorf("Nothing to compare in %s leaderboard", id)
+	}
+
+	topN := opts.TopN
+	if topN <= 0 {
+		topN = DEFAULT_TOP_N
+	}
+
+	prevLaps, err := toLaps(prev)
+	if err != nil {
+		return nil, err
+	}
+	currLaps, err := toLaps(curr)
+	if err != nil {
+		return nil, err
+	}
+
+	event := func(kind notify.Kind, record models.Record, previous *models.Record) notify.Event {
+		return notify.Event{
+			Kind:         kind,
+			CurrentMonth: opts.CurrentMonth,
+			StageID:      id,
+			Record:       record,
+			Previous:     previous,
+		}
+	}
+
+	var events []notify.Event
+	currFirst := getFastestRecord(curr)
+
+	// nothing to compare against on the very first run, only the monthly winner is worth announcing
+	if len(prev) == 0 {
+		if opts.CurrentMonth && currFirst != nil {
+			events = append(events, event(notify.KindNewRecord, *currFirst, nil))
+		}
+		return events, nil
+	}
+
+	prevByPlayer := byPlayer(prevLaps)
+	currByPlayer := byPlayer(currLaps)
+
+	var recordHolder string
+	if prevFirst := getFastestRecord(prev); currFirst != nil {
+		c := currByPlayer[currFirst.Player]
+		if prevFirst == nil {
+			recordHolder = currFirst.Player
+			events = append(events, event(notify.KindNewRecord, *currFirst, nil))
+		} else if p := prevByPlayer[prevFirst.Player]; c.seconds < p.seconds {
+			recordHolder = currFirst.Player
+			e := event(notify.KindNewRecord, *currFirst, prevFirst)
+			e.Improvement = improvement(p.seconds, c.seconds)
+			events = append(events, e)
+		}
+	}
+
+	for _, c := range currLaps {
+		if c.record.Rank > topN || c.record.Player == recordHolder {
+			continue
+		}
+
+		p, existed := prevByPlayer[c.record.Player]
+		switch {
+		case !existed || p.record.Rank > topN:
+			events = append(events, event(notify.KindNewEntrant, c.record, nil))
+		case c.seconds < p.seconds:
+			e := event(notify.KindPersonalBest, c.record, &p.record)
+			e.Improvement = improvement(p.seconds, c.seconds)
+			events = append(events, e)
+		case c.seconds > p.seconds:
+			// a slower time than before means the faster one got invalidated
+			events = append(events, event(notify.KindTimeRemoved, c.record, &p.record))
+		case c.record.Rank < p.record.Rank:
+			events = append(events, event(notify.KindRankGained, c.record, &p.record))
+		case c.record.Rank > p.record.Rank:
+			events = append(events, event(notify.KindRankLost, c.record, &p.record))
+		}
+	}
+
+	var slowest float64
+	for _, c := range currLaps {
+		if c.record.Rank <= topN {
+			slowest = max(slowest, c.seconds)
+		}
+	}
+
+	for _, p := range prevLaps {
+		if p.record.Rank > topN {
+			continue
+		}
+
+		c, exists := currByPlayer[p.record.Player]
+		switch {
+		case exists && c.record.Rank <= topN:
+			continue
+		case exists, len(curr) >= topN && p.seconds >= slowest:
+			// still on the board or pushed out by faster times
+			events = append(events, event(notify.KindDroppedOff, p.record, nil))
+		default:
+			events = append(events, event(notify.KindTimeRemoved, p.record, nil))
+		}
+	}
+
+	return events, nil
+}
+
+func toLaps(records []models.Record) ([]lap, error) {
+	laps := make([]lap, 0, len(records))
+	for _, r := range records {
+		seconds, err := toSeconds(r.Time)
+		if err != nil {
+			return nil, err
+		}
+		laps = append(laps, lap{record: r, seconds: seconds})
+	}
+	return laps, nil
+}
+
+func byPlayer(laps []lap) map[string]lap {
+	m := make(map[string]lap, len(laps))
+	for _, l := range laps {
+		// keep the best entry if a player shows up more than once
+		if existing, ok := m[l.record.Player]; ok && existing.seconds <= l.seconds {
+			continue
+		}
+		m[l.record.Player] = l
+	}
+	return m
+}
+
+func improvement(prev, curr float64) time.Duration {
+	return time.Duration((prev - curr) * float64(time.Second)).Round(time.Millisecond)
+}
+
+// filterEvents keeps only the event kinds that should be announced.
+func filterEvents(events []notify.Event, kinds []notify.Kind) []notify.Event {
+	allowed := make(map[notify.Kind]bool, len(kinds))
+	for _, k := range kinds {
+		allowed[k] = true
+	}
+	filtered := events[:0]
+	for _, e := range events {
+		if allowed[e.Kind] {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
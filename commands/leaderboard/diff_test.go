@@ -0,0 +1,106 @@
+package leaderboard
+
+import (
+	"testing"
+
+	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/notify"
+)
+
+var akina = models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}
+
+func kinds(events []notify.Event) map[string]notify.Kind {
+	m := make(map[string]notify.Kind, len(events))
+	for _, e := range events {
+		m[e.Record.Player] = e.Kind
+	}
+	return m
+}
+
+func TestDiffFirstRun(t *testing.T) {
+	curr := []models.Record{
+		{Rank: 1, Player: "takumi", Time: "02:10.500"},
+	}
+
+	events, err := diff(akina, nil, curr, diffOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("all-time first run should not announce anything, got %v\n", events)
+	}
+
+	events, err = diff(akina, nil, curr, diffOptions{CurrentMonth: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n", err)
+	}
+	if len(events) != 1 || events[0].Kind != notify.KindNewRecord {
+		t.Fatalf("monthly first run should announce the winner, got %v\n", events)
+	}
+}
+
+func TestDiffMovements(t *testing.T) {
+	prev := []models.Record{
+		{Rank: 1, Player: "ryosuke", Time: "02:10.000"},
+		{Rank: 2, Player: "keisuke", Time: "02:11.000"},
+		{Rank: 3, Player: "iketani", Time: "02:20.000"},
+		{Rank: 4, Player: "itsuki", Time: "02:30.000"},
+	}
+	curr := []models.Record{
+		{Rank: 1, Player: "takumi", Time: "02:09.500"},
+		{Rank: 2, Player: "ryosuke", Time: "02:10.000"},
+		{Rank: 3, Player: "keisuke", Time: "02:10.800"},
+		{Rank: 4, Player: "iketani", Time: "02:20.000"},
+	}
+
+	events, err := diff(akina, prev, curr, diffOptions{TopN: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n", err)
+	}
+
+	got := kinds(events)
+	want := map[string]notify.Kind{
+		"takumi":  notify.KindNewRecord,
+		"ryosuke": notify.KindRankLost,
+		"keisuke": notify.KindPersonalBest,
+		"iketani": notify.KindRankLost,
+		"itsuki":  notify.KindDroppedOff,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %v\n", len(want), events)
+	}
+	for player, kind := range want {
+		if got[player] != kind {
+			t.Errorf("expected %s for %s, got %s\n", kind, player, got[player])
+		}
+	}
+
+	for _, e := range events {
+		if e.Kind == notify.KindNewRecord && (e.Previous == nil || e.Previous.Player != "ryosuke" || e.Improvement.Milliseconds() != 500) {
+			t.Errorf("new record should be compared against the previous holder, got %+v\n", e)
+		}
+	}
+}
+
+func TestDiffTimeRemoved(t *testing.T) {
+	prev := []models.Record{
+		{Rank: 1, Player: "takumi", Time: "02:09.000"},
+		{Rank: 2, Player: "ryosuke", Time: "02:10.000"},
+	}
+	curr := []models.Record{
+		{Rank: 1, Player: "ryosuke", Time: "02:10.000"},
+	}
+
+	events, err := diff(akina, prev, curr, diffOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n", err)
+	}
+
+	got := kinds(events)
+	if got["takumi"] != notify.KindTimeRemoved {
+		t.Fatalf("expected takumi's time to be removed, got %v\n", events)
+	}
+	if got["ryosuke"] != notify.KindRankGained {
+		t.Fatalf("expected ryosuke to gain a rank, got %v\n", events)
+	}
+}
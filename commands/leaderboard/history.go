@@ -0,0 +1,109 @@
+package leaderboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dimfu/kaido/collectors"
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/store"
+	"github.com/urfave/cli/v3"
+)
+
+// History prints who held the record on a stage at a given date, or every stored snapshot with --timeline.
+func History(ctx context.Context, c *cli.Command) error {
+	track, stage := c.String("track"), c.String("stage")
+	if len(track) == 0 || len(stage) == 0 {
+		return errors.New("both track and stage are required")
+	}
+
+	at := time.Now()
+	if c.IsSet("at") {
+		date, err := time.ParseInLocation(time.DateOnly, c.String("at"), time.Local)
+		if err != nil {
+			return fmt.Errorf("date must be YYYY-MM-DD: %v", err)
+		}
+		// include every snapshot taken during that day
+		at = date.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	cfg := config.GetConfig()
+	region, err := findRegion(cfg.Leaderboards, c.String("region"), track)
+	if err != nil {
+		return err
+	}
+
+	root, err := store.GetInstance(ctx)
+	if err != nil {
+		return err
+	}
+	s := store.Namespace(root, cfg.Namespace)
+	if _, err := collectors.MigrateStageKeys(s, cfg.Leaderboards); err != nil {
+		return err
+	}
+
+	key := collectors.StageKey(models.StageID{Region: region, Track: track, Stage: stage}, c.Bool("current_month"), at)
+
+	if c.Bool("timeline") {
+		records, err := s.History(key)
+		if err != nil {
+			return fmt.Errorf("no history for %s: %v", key, err)
+		}
+		for _, r := range records {
+			if err := printHolder(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r, err := store.At(s, key, at)
+	if err != nil {
+		return fmt.Errorf("no records for %s at %s: %v", key, at.Format(time.DateOnly), err)
+	}
+	return printHolder(r)
+}
+
+// findRegion is the leaderboard the track belongs to, it only has to be given when several leaderboards
+// share the track.
+func findRegion(leaderboards models.Leaderboards, region, track string) (string, error) {
+	if len(region) > 0 {
+		return region, nil
+	}
+	var found []string
+	for name, l := range leaderboards {
+		if slices.ContainsFunc(l.Tracks, func(t models.Track) bool { return t.Name == track }) {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("cannot find track %s in any leaderboard, pass --region", track)
+	case 1:
+		return found[0], nil
+	}
+	slices.Sort(found)
+	return "", fmt.Errorf("track %s is in several leaderboards, pick one of %s with --region", track, strings.Join(found, ", "))
+}
+
+func printHolder(r *store.Record) error {
+	var records []models.Record
+	if err := json.Unmarshal(r.Value, &records); err != nil {
+		return err
+	}
+
+	taken := time.Unix(0, r.Timestamp).Format(time.DateTime)
+	first := getFastestRecord(records)
+	if first == nil {
+		fmt.Printf("%s  no record holder\n", taken)
+		return nil
+	}
+	fmt.Printf("%s  %s %s (%s)\n", taken, first.Player, first.Time, first.CarName)
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"regexp"
 	"slices"
 	"strconv"
@@ -13,51 +14,80 @@ import (
 
 	"github.com/dimfu/kaido/collectors"
 	"github.com/dimfu/kaido/config"
-	"github.com/dimfu/kaido/discord"
 	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/notify"
 	"github.com/dimfu/kaido/store"
 	"github.com/urfave/cli/v3"
 )
 
 const (
-	ALL_TIME_STR   = "New all-time fastest lap! %s in %s by %s\n"
-	CURR_MONTH_STR = "New fastest lap this month! %s in %s by %s\n"
-)
-
-var (
-	cfg = config.GetConfig()
-	mu  sync.Mutex
-	sb  strings.Builder
+	// COLLECT_TIMEOUT is how long a run waits for the leaderboards before giving up on the rest
+	COLLECT_TIMEOUT = 10 * time.Second
 )
 
 func List(ctx context.Context, c *cli.Command) error {
-	for region := range cfg.Leaderboards {
+	for region := range config.GetConfig().Leaderboards {
 		fmt.Println(region)
 	}
 	return nil
 }
 
-func Extract(ctx context.Context, c *cli.Command) error {
-	start := time.Now()
-	cfg := config.GetConfig()
-	s, err := store.GetInstance()
+func Refresh(ctx context.Context, c *cli.Command) error {
+	return RefreshProfile(config.GetConfig())
+}
+
+// RefreshProfile re-discovers the leaderboards of the profile cfg uses.
+func RefreshProfile(cfg *config.Config) error {
+	changes, err := collectors.RefreshTimingLeaderboards(cfg)
+	if changes == nil {
+		return fmt.Errorf("cannot refresh leaderboards: %v", err)
+	}
 	if err != nil {
-		return err
+		// failed regions keep their previous tracks
+		fmt.Println(err)
 	}
 
-	leaderboardFlag := c.String("leaderboard")
-	currentMonth := c.Bool("current_month")
+	if len(changes.Added) == 0 && len(changes.Removed) == 0 {
+		fmt.Println("Leaderboards are up to date")
+		return nil
+	}
+	for _, id := range changes.Added {
+		fmt.Printf("+ %s\n", id)
+	}
+	for _, id := range changes.Removed {
+		fmt.Printf("- %s\n", id)
+	}
+	fmt.Printf("%d stages added, %d removed\n", len(changes.Added), len(changes.Removed))
 
-	leaderboard := strings.ToLower(leaderboardFlag)
+	return nil
+}
 
-	timing := collectors.TimingTable{
-		Store:        s,
-		Cfg:          cfg,
-		CurrentMonth: currentMonth,
+// Discover crawls the leaderboards of the profile cfg uses the first time they are needed.
+func Discover(cfg *config.Config) error {
+	if err := collectors.GenerateTimingLeaderboards(cfg); err != nil {
+		var discoveryErr *collectors.DiscoveryError
+		switch {
+		case errors.Is(err, collectors.ERR_ALREADY_GENERATED):
+		case errors.As(err, &discoveryErr):
+			// the other regions are usable, the missing ones can be fetched with `kaido refresh`
+			log.Println(err)
+		default:
+			return fmt.Errorf("cannot get leaderboard tracks data: %v", err)
+		}
 	}
+	return nil
+}
+
+func Extract(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+	return Collect(ctx, cfg, ParseLeaderboards(cfg, c.String("leaderboard")), c.Bool("current_month"))
+}
 
+// ParseLeaderboards splits a comma separated leaderboard flag into region names,
+// expanding "all" into every known leaderboard.
+func ParseLeaderboards(cfg *config.Config, flag string) []string {
 	re := regexp.MustCompile(`\s*,\s*`)
-	leaderboards := re.Split(leaderboard, -1)
+	leaderboards := re.Split(strings.ToLower(strings.TrimSpace(flag)), -1)
 
 	// handle if one of the leaderboard item including "all" by adding the whole leaderboard list instead
 	if slices.Contains(leaderboards, "all") {
@@ -66,68 +96,99 @@ func Extract(ctx context.Context, c *cli.Command) error {
 			leaderboards = append(leaderboards, region)
 		}
 	}
+	return leaderboards
+}
 
-	var wg sync.WaitGroup
+// Collect scrapes the given leaderboards of the profile cfg uses once and announces new records.
+func Collect(ctx context.Context, cfg *config.Config, leaderboards []string, currentMonth bool) error {
+	start := time.Now()
+	root, err := store.GetInstance(ctx)
+	if err != nil {
+		return err
+	}
+	// every profile keeps its records and outbox apart
+	s := store.Namespace(root, cfg.Namespace)
+
+	// snapshots stored before the region was part of their key
+	if _, err := collectors.MigrateStageKeys(s, cfg.Leaderboards); err != nil {
+		return err
+	}
 
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+	notifiers, err := notify.FromConfig(cfg)
+	if err != nil {
+		return err
+	}
 
-	for _, leaderboard := range leaderboards {
-		wg.Add(1)
-		go func(leaderboard string, currentMonth bool) {
-			defer wg.Done()
-			results, err := timing.Extract(leaderboard)
+	outbox := &notify.Outbox{Store: s}
+
+	kinds := defaultEvents
+	if len(cfg.Events) > 0 {
+		kinds = make([]notify.Kind, 0, len(cfg.Events))
+		for _, e := range cfg.Events {
+			kinds = append(kinds, notify.Kind(e))
+		}
+	}
+
+	timing := collectors.TimingTable{
+		Store:        s,
+		Cfg:          cfg,
+		CurrentMonth: currentMonth,
+		// record the announcement before the snapshot is replaced, so it is not lost if sending fails
+		BeforeCommit: func(r collectors.TimingResult) error {
+			events, err := diff(r.ID, r.Prev, r.Curr, diffOptions{
+				TopN:         cfg.TopN,
+				CurrentMonth: currentMonth,
+			})
 			if err != nil {
 				fmt.Println(err)
-				return
+				return nil
 			}
-
-			for region, result := range results {
-				currTop, err := compare(region, result.Prev, result.Curr, currentMonth)
-				if err != nil {
-					fmt.Println(err)
-				}
-				mu.Lock()
-				sb.Write([]byte(currTop))
-				mu.Unlock()
+			events = filterEvents(events, kinds)
+			for i := range events {
+				events[i].Url = r.Url
 			}
-		}(leaderboard, currentMonth)
-	}
-
-	select {
-	case <-done:
-		if sb.Len() > 0 {
-			players := strings.Split(sb.String(), "\n")
-			batchSize := 10
-
-			var wg sync.WaitGroup
-
-			for i := 0; i < len(players); i += batchSize {
-				wg.Add(1)
-				end := i + batchSize
-				if end > len(players) {
-					end = len(players)
-				}
-				go func(ps []string) {
-					defer wg.Done()
-					toStr := strings.Join(ps, "\n")
-					err := discord.Send(toStr, cfg.DiscordWebhookURL)
-					if err != nil {
-						fmt.Println(err)
-					}
-				}(players[i:end])
+			return outbox.Enqueue(events...)
+		},
+	}
+
+	// the workers are always waited for, a poll that returned while they still write to the store
+	// would overlap the next one
+	collectCtx, cancel := context.WithTimeout(ctx, COLLECT_TIMEOUT)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, leaderboard := range leaderboards {
+		wg.Add(1)
+		go func(leaderboard string) {
+			defer wg.Done()
+			if _, err := timing.Extract(collectCtx, leaderboard); err != nil {
+				fmt.Println(err)
 			}
-			wg.Wait()
-		}
+		}(leaderboard)
+	}
+	wg.Wait()
 
-		fmt.Printf("Success collecting records from %d leaderboards (took %s)\n", len(leaderboards), time.Since(start))
-	case <-time.After(10 * time.Second):
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if collectCtx.Err() != nil {
 		fmt.Println("Timeout")
 	}
 
+	if notifiers.Len() == 0 {
+		fmt.Println("No notifier configured, announcements are kept in the outbox")
+	} else {
+		// a failing sink does not hold back the announcements the others accepted
+		sent, err := outbox.Drain(ctx, notifiers)
+		if sent > 0 {
+			fmt.Printf("Delivered %d announcements\n", sent)
+		}
+		if err != nil {
+			fmt.Printf("Failed to deliver announcements, will retry on next run: %v\n", err)
+		}
+	}
+	fmt.Printf("Success collecting records from %d leaderboards (took %s)\n", len(leaderboards), time.Since(start))
+
 	return nil
 }
 
@@ -160,48 +221,6 @@ func toSeconds(time string) (float64, error) {
 	return float64(minutes*60+seconds) + float64(milliseconds)/1000.0, nil
 }
 
-func compare(region string, prev, curr []models.Record, currMonth bool) (string, error) {
-	prevFirst, currFirst := getFastestRecord(prev), getFastestRecord(curr)
-
-	if prevFirst == nil && currFirst == nil {
-		return "", fmt.Errorf("Cannot find records in %s, skipping...", region)
-	}
-
-	var t1, t2 float64
-	var err error
-
-	if prevFirst != nil {
-		t1, err = toSeconds(prevFirst.Time)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	if currFirst != nil {
-		t2, err = toSeconds(currFirst.Time)
-		if err != nil {
-			return "", err
-		}
-
-		// handle current month winner if there is no prev record
-		if prevFirst == nil && currMonth {
-			return fmt.Sprintf(CURR_MONTH_STR, currFirst.Time, region, currFirst.Player), nil
-		}
-	} else {
-		return "", fmt.Errorf("Nothing to compare in %s leaderboard", region)
-	}
-
-	if t2 < t1 {
-		msg := ALL_TIME_STR
-		if currMonth {
-			msg = CURR_MONTH_STR
-		}
-		return fmt.Sprintf(msg, currFirst.Time, region, currFirst.Player), nil
-	}
-
-	return "", nil
-}
-
 func getFastestRecord(records []models.Record) *models.Record {
 	if len(records) > 0 && records[0].Rank == 1 {
 		return &records[0]
@@ -0,0 +1,44 @@
+package settings
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/urfave/cli/v3"
+)
+
+// Profiles lists the profiles of the config, the one in use is marked with a *.
+func Profiles(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+	if !cfg.Loaded() {
+		return config.ERR_NOT_INITIALIZED
+	}
+	for _, name := range cfg.ProfileNames() {
+		profile, err := cfg.For(name)
+		if err != nil {
+			return err
+		}
+		mark := " "
+		if name == cfg.ActiveProfile() {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\t%s\n", mark, name, profile.KBTBaseUrl)
+	}
+	return nil
+}
+
+// UseProfile makes a profile the one used when --profile is not given.
+func UseProfile(ctx context.Context, c *cli.Command) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: kaido profile use <profile>")
+	}
+	name := c.Args().First()
+	return update(func(cfg *config.Config) error {
+		if !cfg.HasProfile(name) {
+			return fmt.Errorf("unknown profile %s, create it with `kaido --profile %s init`", name, name)
+		}
+		cfg.DefaultProfile = name
+		return nil
+	})
+}
@@ -0,0 +1,229 @@
+package settings
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	FLAG_CATEGORY  = "settings"
+	DEFAULT_EDITOR = "vi"
+)
+
+// Flags has a global flag for every setting so any of them can be overridden for a single run.
+func Flags() []cli.Flag {
+	var flags []cli.Flag
+	for _, s := range config.Settings() {
+		if !s.Overridable() {
+			continue
+		}
+		usage := fmt.Sprintf("%s [$%s]", s.Usage, s.Env())
+		if s.Multiple() {
+			flags = append(flags, &cli.StringSliceFlag{Name: s.Flag(), Usage: usage, Category: FLAG_CATEGORY})
+			continue
+		}
+		flags = append(flags, &cli.StringFlag{Name: s.Flag(), Usage: usage, Category: FLAG_CATEGORY})
+	}
+	return flags
+}
+
+// Apply overrides the settings given as flags, it runs after the config file and environment are loaded.
+func Apply(cfg *config.Config, c *cli.Command) error {
+	for _, s := range config.Settings() {
+		if !s.Overridable() || !c.IsSet(s.Flag()) {
+			continue
+		}
+		values := []string{c.String(s.Flag())}
+		if s.Multiple() {
+			values = c.StringSlice(s.Flag())
+		}
+		if err := cfg.Override(s.Key, values, config.SOURCE_FLAG); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Show prints the config file, or with --effective every setting as it is used along with where it came from.
+func Show(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+
+	if !c.Bool("effective") {
+		buf, err := os.ReadFile(cfg.Path())
+		if os.IsNotExist(err) {
+			return config.ERR_NOT_INITIALIZED
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+
+	fmt.Printf("Profile: %s\n\n", cfg.ActiveProfile())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "SETTING\tVALUE\tSOURCE\n")
+	for _, s := range config.Settings() {
+		value, err := cfg.Value(s.Key, c.Bool("reveal"))
+		if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			value = `""`
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, value, cfg.Source(s.Key))
+	}
+	fmt.Fprintf(w, "leaderboards\t%d regions\t%s\n", len(cfg.Leaderboards), config.SOURCE_FILE)
+	fmt.Fprintf(w, "routes\t%d routes\t%s\n", len(cfg.Routes), config.SOURCE_FILE)
+	return w.Flush()
+}
+
+// Get prints the value of a setting as it is used, webhook urls are masked unless --reveal is given.
+func Get(ctx context.Context, c *cli.Command) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: kaido config get <setting>")
+	}
+	value, err := config.GetConfig().Value(c.Args().First(), c.Bool("reveal"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// Set saves a setting to the config file, lists take every remaining argument.
+func Set(ctx context.Context, c *cli.Command) error {
+	if c.Args().Len() < 2 {
+		return fmt.Errorf("usage: kaido config set <setting> <value>...")
+	}
+	return update(func(cfg *config.Config) error {
+		return cfg.Set(c.Args().First(), c.Args().Tail())
+	})
+}
+
+// Unset removes a setting from the config file so its default is used again.
+func Unset(ctx context.Context, c *cli.Command) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: kaido config unset <setting>")
+	}
+	return update(func(cfg *config.Config) error {
+		return cfg.Unset(c.Args().First())
+	})
+}
+
+// update changes the config and saves it only if the result is valid.
+func update(change func(cfg *config.Config) error) error {
+	cfg := config.GetConfig()
+	if !cfg.Loaded() {
+		return config.ERR_NOT_INITIALIZED
+	}
+	if err := change(cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config was not saved:\n%v", err)
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Saved config to %s\n", cfg.Path())
+	return nil
+}
+
+// Validate checks the config in use, environment variables and flags included.
+func Validate(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+	if err := cfg.ReadError(); err != nil {
+		return fmt.Errorf("%s is not valid:\n%v", cfg.Path(), err)
+	}
+	if !cfg.Loaded() {
+		return config.ERR_NOT_INITIALIZED
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%s is not valid:\n%v", cfg.Path(), err)
+	}
+	fmt.Printf("%s is valid\n", cfg.Path())
+	return nil
+}
+
+// Edit opens the config file in $VISUAL or $EDITOR, the changes are only written back when they are valid.
+func Edit(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+	// a file that cannot be read is opened as it is so it can be fixed
+	if !cfg.Loaded() && cfg.ReadError() == nil {
+		return config.ERR_NOT_INITIALIZED
+	}
+
+	original, err := os.ReadFile(cfg.Path())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "kaido-config-*.json")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	if _, err := tmp.Write(original); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := openEditor(ctx, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(original, edited) {
+		os.Remove(tmp.Name())
+		fmt.Println("Config unchanged")
+		return nil
+	}
+
+	parsed, err := config.Parse(edited, cfg.Path())
+	if err == nil {
+		err = parsed.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("config was not saved:\n%v\nyour changes are kept in %s", err, tmp.Name())
+	}
+
+	if err := os.WriteFile(cfg.Path(), edited, 0600); err != nil {
+		return err
+	}
+	os.Remove(tmp.Name())
+	fmt.Printf("Saved config to %s\n", cfg.Path())
+	return nil
+}
+
+// openEditor runs the editor of the user on path, the editor may come with arguments eg; "code --wait".
+func openEditor(ctx context.Context, path string) error {
+	editor := os.Getenv("VISUAL")
+	if len(editor) == 0 {
+		editor = os.Getenv("EDITOR")
+	}
+	if len(editor) == 0 {
+		editor = DEFAULT_EDITOR
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %v", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/discord"
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	ERR_WEBHOOK_REQUIRED = errors.New("a discord webhook url is required, pass --discord_webhook_url or set KAIDO_DISCORD_WEBHOOK_URL")
+)
+
+// Init writes the config. Settings given as flags are saved, settings from the environment are
+// used but stay out of the file. A missing webhook url is asked for when stdin is a terminal and
+// --non_interactive is not set.
+func Init(ctx context.Context, c *cli.Command) error {
+	cfg := config.GetConfig()
+	webhookSource := cfg.Source("discord_webhook_url")
+	cfg.Persist(config.SOURCE_FLAG)
+
+	// the webhook is checked for real below, so a prompted one is always a working url
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config was not saved:\n%v", err)
+	}
+	if err := os.MkdirAll(cfg.WorkspacePath, os.ModePerm); err != nil {
+		return err
+	}
+
+	switch {
+	case webhookSource == config.SOURCE_FLAG:
+		if err := discord.Prompt(cfg.DiscordWebhookURL); err != nil {
+			return err
+		}
+	case len(cfg.DiscordWebhookURL) > 0:
+	case c.Bool("non_interactive") || !interactive():
+		return ERR_WEBHOOK_REQUIRED
+	default:
+		if err := discord.Prompt(); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved config to %s\n", cfg.Path())
+	return nil
+}
+
+// interactive reports whether stdin is a terminal the webhook url can be asked on.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
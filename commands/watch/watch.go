@@ -0,0 +1,153 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dimfu/kaido/commands/leaderboard"
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/scheduler"
+	"github.com/dimfu/kaido/store"
+	"github.com/urfave/cli/v3"
+)
+
+func Watch(ctx context.Context, c *cli.Command) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// open the store up front so every poll reuses the same instance, waiting for the workspace
+	// lock stops on interrupt
+	if _, err := store.GetInstance(ctx); err != nil {
+		return err
+	}
+
+	jobs, err := buildJobs(c)
+	if err != nil {
+		return err
+	}
+
+	scheduler.New(jobs...).Run(ctx)
+	fmt.Println("Shutting down watcher")
+
+	return nil
+}
+
+// buildJobs schedules every profile, or only the one picked with --profile.
+func buildJobs(c *cli.Command) ([]scheduler.Job, error) {
+	cfg := config.GetConfig()
+	profiles := []string{cfg.ActiveProfile()}
+	if !cfg.ExplicitProfile() {
+		profiles = cfg.ProfileNames()
+	}
+
+	var jobs []scheduler.Job
+	for _, name := range profiles {
+		profile, err := cfg.For(name)
+		if err != nil {
+			return nil, err
+		}
+		// an unreachable server only holds back its own profile, its polls retry the discovery
+		discoverErr := leaderboard.Discover(profile)
+		if discoverErr != nil {
+			log.Printf("profile %s: %v, retrying on its next poll\n", name, discoverErr)
+		} else if err := profile.Validate(); err != nil {
+			// leaderboard regions of the schedules can only be checked once they are discovered
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+
+		scheduled, err := profileJobs(c, profile)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+		if discoverErr != nil {
+			for i := range scheduled {
+				scheduled[i].Run = discoverFirst(profile, scheduled[i].Run)
+			}
+		}
+		if len(profiles) > 1 {
+			for i := range scheduled {
+				scheduled[i].Name = name + ": " + scheduled[i].Name
+			}
+		}
+		jobs = append(jobs, scheduled...)
+	}
+
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("nothing to watch, every schedule is disabled")
+	}
+	return jobs, nil
+}
+
+// discoverFirst makes run wait for the leaderboards of the profile, Discover is a no-op once they are known.
+func discoverFirst(cfg *config.Config, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := leaderboard.Discover(cfg); err != nil {
+			return err
+		}
+		return run(ctx)
+	}
+}
+
+func profileJobs(c *cli.Command, cfg *config.Config) ([]scheduler.Job, error) {
+	schedules := cfg.Schedules
+
+	// fallback to the flags when there is no schedule configured
+	if len(schedules) == 0 {
+		lb := c.String("leaderboard")
+		schedules = []config.Schedule{
+			{Leaderboard: lb, Interval: c.Duration("interval").String()},
+			{Leaderboard: lb, Interval: c.Duration("month_interval").String(), CurrentMonth: true},
+		}
+	}
+
+	jobs := make([]scheduler.Job, 0, len(schedules))
+	for _, s := range schedules {
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for %s schedule: %v", s.Leaderboard, err)
+		}
+		if interval <= 0 {
+			continue
+		}
+
+		scope := "all-time"
+		if s.CurrentMonth {
+			scope = "current month"
+		}
+
+		flag, currentMonth := s.Leaderboard, s.CurrentMonth
+		jobs = append(jobs, scheduler.Job{
+			Name:     fmt.Sprintf("%s (%s)", s.Leaderboard, scope),
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				// expanded on every poll so "all" picks up the regions a refresh found
+				return leaderboard.Collect(ctx, cfg, leaderboard.ParseLeaderboards(cfg, flag), currentMonth)
+			},
+		})
+	}
+
+	var refresh time.Duration
+	if len(cfg.RefreshInterval) > 0 {
+		interval, err := time.ParseDuration(cfg.RefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refresh interval: %v", err)
+		}
+		refresh = interval
+	}
+	if refresh > 0 {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "leaderboard refresh",
+			Interval: refresh,
+			Run: func(ctx context.Context) error {
+				return leaderboard.RefreshProfile(cfg)
+			},
+		})
+	}
+
+	return jobs, nil
+}
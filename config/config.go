@@ -1,19 +1,97 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+)
+
+const (
+	HOME_ENV    = "KAIDO_HOME"
+	CONFIG_FILE = "config.json"
+
+	DEFAULT_KBT_BASE_URL = "http://5.161.130.32:8000"
+)
+
+var (
+	ERR_NOT_INITIALIZED = errors.New("kaido is not initialized, run `kaido init` first")
+	ERR_INVALID_FILE    = errors.New("fix it with `kaido config edit`")
 
-	"github.com/dimfu/kaido/models"
+	// EventKinds are the kinds of changes the events setting can pick from
+	EventKinds = []string{"new_record", "new_entrant", "personal_best", "rank_gained", "rank_lost", "dropped_off", "time_removed"}
 )
 
 type Config struct {
-	WorkspacePath     string              `json:"workspace_path"`
-	KBTBaseUrl        string              `json:"kbt_base_url"`
-	Leaderboards      models.Leaderboards `json:"leaderboards"`
-	DiscordWebhookURL string              `json:"discord_webhook_url"`
+	// Version is the format of the config file, see CONFIG_VERSION
+	Version       int    `json:"version"`
+	WorkspacePath string `json:"workspace_path,omitempty"`
+	// DefaultProfile is used when no profile is picked with --profile, default to "default"
+	DefaultProfile string              `json:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles"`
+	// Profile is the profile in use, its fields read as the fields of the config
+	*Profile `json:"-"`
+
+	// TopN is how many places of each leaderboard are watched for changes
+	TopN int `json:"top_n,omitempty"`
+	// Events lists the kinds of changes that are announced, empty means the defaults
+	Events []string `json:"events,omitempty"`
+	// RefreshInterval makes the watch daemon re-discover leaderboards periodically
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+	// History controls how many old leaderboard snapshots are kept
+	History History `json:"history,omitempty"`
+	Store   Store   `json:"store,omitempty"`
+
+	// path is where the config was loaded from, empty means config.json in the workspace
+	path string
+	// loaded is set once the config was read from its file, readErr is why it could not be
+	loaded  bool
+	readErr error
+	// sources tells where every setting that is not a default came from
+	sources map[string]Source
+	// overrides holds the values set by the environment or flags, base the values of the file
+	overrides map[string]any
+	base      *Config
+	// active is the name of the profile in use, explicit is set when it was picked with --profile or KAIDO_PROFILE
+	active   string
+	explicit bool
+	// root is the config a view of another profile was made from, views are saved through it
+	root *Config
+}
+
+type Store struct {
+	// Backend is "log" for the on-disk store or "memory" to keep nothing between runs, default to log
+	Backend string `json:"backend,omitempty"`
+	// CompactThreshold is the share of wasted space (0-1) that triggers compaction, default to 0.5
+	CompactThreshold float64 `json:"compact_threshold,omitempty"`
+	// MaxSegmentMB is the size of a store file before writes move on to a new one, default to 64
+	MaxSegmentMB int `json:"max_segment_mb,omitempty"`
+}
+
+// History is the retention policy of leaderboard snapshots, zero keeps everything.
+type History struct {
+	MaxAgeDays  int `json:"max_age_days,omitempty"`
+	MaxVersions int `json:"max_versions,omitempty"`
+}
+
+// Notifier is an extra destination for record announcements.
+type Notifier struct {
+	Type       string `json:"type"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// Schedule tells the watch daemon how often a leaderboard should be polled.
+type Schedule struct {
+	Leaderboard  string `json:"leaderboard"`
+	CurrentMonth bool   `json:"current_month"`
+	Interval     string `json:"interval"`
 }
 
 var (
@@ -28,16 +106,281 @@ func GetConfig() *Config {
 	return instance
 }
 
+// DefaultWorkspace is where kaido keeps its files when no other workspace is given.
+func DefaultWorkspace() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(home, ".kaido"), nil
+}
+
+// Path is the file the config is saved to.
+func (c *Config) Path() string {
+	if len(c.path) > 0 {
+		return c.path
+	}
+	return path.Join(c.WorkspacePath, CONFIG_FILE)
+}
+
+// defaults is the config before any layer is applied.
+func defaults() Config {
+	return Config{
+		Version: CONFIG_VERSION,
+		Profile: newProfile(DEFAULT_PROFILE),
+	}
+}
+
+// Parse reads an encoded config file, older versions are migrated. Unknown fields are rejected
+// so typos do not go unnoticed, path is where the file lives.
+func Parse(encoded []byte, path string) (*Config, error) {
+	cfg, _, err := parse(encoded, path)
+	return cfg, err
+}
+
+func parse(encoded []byte, path string) (*Config, int, error) {
+	migrated, version, err := migrate(encoded, filepath.Dir(path))
+	if err != nil {
+		return nil, version, fmt.Errorf("cannot read %s: %v", path, err)
+	}
+
+	cfg := defaults()
+	decoder := json.NewDecoder(bytes.NewReader(migrated))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, version, fmt.Errorf("cannot read %s: %v", path, err)
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil {
+			cfg.Profiles[name] = newProfile(name)
+		} else if len(p.KBTBaseUrl) == 0 {
+			p.KBTBaseUrl = DEFAULT_KBT_BASE_URL
+		}
+	}
+	if err := cfg.Use(cfg.DefaultProfile); err != nil {
+		return nil, version, fmt.Errorf("cannot read %s: %v", path, err)
+	}
+	return &cfg, version, nil
+}
+
+// Load layers the config file and the KAIDO_ environment variables over the defaults into the shared
+// config. Empty workDir and cfgPath fall back to KAIDO_HOME, the workspace saved in the config and
+// ~/.kaido, an explicit workDir always wins. An empty profile falls back to KAIDO_PROFILE and the
+// default profile of the config.
+func Load(workDir, cfgPath, profile string) (*Config, error) {
+	cfg := GetConfig()
+	*cfg = defaults()
+	cfg.sources = make(map[string]Source)
+	cfg.overrides = make(map[string]any)
+
+	workSource := SOURCE_FLAG
+	if len(workDir) == 0 {
+		workDir, workSource = os.Getenv(HOME_ENV), SOURCE_ENV
+	}
+
+	if len(cfgPath) == 0 {
+		dir := workDir
+		if len(dir) == 0 {
+			defaultDir, err := DefaultWorkspace()
+			if err != nil {
+				return nil, err
+			}
+			dir = defaultDir
+		}
+		cfgPath = path.Join(dir, CONFIG_FILE)
+	}
+	cfg.path = cfgPath
+
+	encoded, err := os.ReadFile(cfgPath)
+	switch {
+	case err == nil:
+		parsed, version, err := parse(encoded, cfgPath)
+		if err != nil {
+			// the defaults are kept so the commands fixing the file can still run
+			cfg.readErr = err
+			return cfg, fmt.Errorf("%v, %w", err, ERR_INVALID_FILE)
+		}
+		parsed.path, parsed.sources, parsed.overrides = cfg.path, cfg.sources, cfg.overrides
+		*cfg = *parsed
+
+		if version != CONFIG_VERSION {
+			if encoded, err = cfg.upgrade(encoded, version); err != nil {
+				return nil, err
+			}
+		}
+		cfg.loaded = true
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	if len(profile) == 0 {
+		profile = os.Getenv(PROFILE_ENV)
+	}
+	cfg.explicit = len(profile) > 0
+	if !cfg.explicit {
+		profile = cfg.DefaultProfile
+	}
+	if err := cfg.Use(profile); err != nil {
+		return nil, err
+	}
+	if cfg.loaded {
+		// the profile has to be known to tell which of its settings are in the file
+		cfg.markFile(encoded)
+	}
+	cfg.base = clone(cfg)
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if len(workDir) > 0 {
+		if err := cfg.Override("workspace_path", []string{workDir}, workSource); err != nil {
+			return nil, err
+		}
+	} else if len(cfg.WorkspacePath) == 0 {
+		cfg.WorkspacePath = filepath.Dir(cfgPath)
+	}
+
+	return cfg, nil
+}
+
+// upgrade writes the migrated config over the file, a copy of the old version is kept next to it.
+func (c *Config) upgrade(old []byte, version int) ([]byte, error) {
+	backup := fmt.Sprintf("%s.v%d.bak", c.Path(), version)
+	if err := os.WriteFile(backup, old, 0600); err != nil {
+		return nil, fmt.Errorf("cannot back up %s: %v", c.Path(), err)
+	}
+	encoded, err := encode(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(c.Path(), encoded, 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("upgraded %s to config version %d, the previous one was kept as %s\n", c.Path(), CONFIG_VERSION, backup)
+	return encoded, nil
+}
+
+// markFile sets the source of every setting present in the encoded config to the file.
+func (c *Config) markFile(encoded []byte) {
+	var raw map[string]any
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return
+	}
+	for _, s := range Settings() {
+		key := s.Key
+		if s.profile {
+			key = "profiles." + c.active + "." + key
+		}
+		if _, overridden := c.overrides[s.Key]; !overridden && present(raw, key) {
+			c.sources[s.Key] = SOURCE_FILE
+		}
+	}
+}
+
+func present(raw map[string]any, key string) bool {
+	parent, name, nested := strings.Cut(key, ".")
+	v, ok := raw[parent]
+	if !ok || !nested {
+		return ok
+	}
+	child, ok := v.(map[string]any)
+	return ok && present(child, name)
+}
+
+// clone deep copies the saved part of the config, the copy uses the same profile.
+func clone(c *Config) *Config {
+	out := &Config{}
+	if bytes, err := json.Marshal(c); err == nil {
+		json.Unmarshal(bytes, out)
+	}
+	if c.Profile != nil {
+		out.active = c.active
+		out.Profile = out.Profiles[c.active]
+		if out.Profile == nil {
+			// not saved yet
+			p := *c.Profile
+			out.Profile = &p
+		}
+	}
+	return out
+}
+
+// Loaded reports whether the config was read from a file, it is not the case before `kaido init`.
+func (c *Config) Loaded() bool {
+	return c.loaded
+}
+
+// ReadError is why the config file could not be read, it is nil when the file was read or does not exist.
+func (c *Config) ReadError() error {
+	return c.readErr
+}
+
+// Save writes the config file. Values from the environment or flags are left out unless they were
+// changed since, so secrets given through the environment never end up on disk.
 func (c *Config) Save() error {
-	file, err := os.Create(path.Join(c.WorkspacePath, "config.json"))
+	if c.root != nil {
+		// only the root knows which values of the profile in use came from the environment or flags,
+		// the profile of the view is saved along as it is shared with the root
+		return c.root.Save()
+	}
+	if c.Profile != nil && c.Profiles[c.active] == nil {
+		// first save of a new profile
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]*Profile)
+		}
+		c.Profiles[c.active] = c.Profile
+	}
+
+	out := clone(c)
+	for key, v := range c.overrides {
+		s, _ := LookupSetting(key)
+		if !reflect.DeepEqual(s.value(c).Interface(), v) {
+			// changed after loading, it belongs to the file now
+			c.sources[key] = SOURCE_FILE
+			delete(c.overrides, key)
+			continue
+		}
+		if c.base != nil {
+			s.value(out).Set(s.value(c.base))
+		} else {
+			s.value(out).SetZero()
+		}
+	}
+
+	out.Version = CONFIG_VERSION
+	// the workspace defaults to the directory of the config, the same as the 0 -> 1 migration it is not pinned
+	if filepath.Clean(out.WorkspacePath) == filepath.Clean(filepath.Dir(c.Path())) {
+		out.WorkspacePath = ""
+	}
+
+	encoded, err := encode(out)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if err := os.MkdirAll(filepath.Dir(c.Path()), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.Path(), encoded, 0600); err != nil {
+		return err
+	}
 
-	encoder := json.NewEncoder(file)
+	c.loaded = true
+	c.base = out
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.markFile(encoded)
+	return nil
+}
+
+func encode(c *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
 	encoder.SetIndent("", "  ")
 	encoder.SetEscapeHTML(false)
-
-	return encoder.Encode(c)
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
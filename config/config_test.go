@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dimfu/kaido/models"
+)
+
+func TestParseSchedule(t *testing.T) {
+	var schedules []Schedule
+	for _, raw := range []string{"month:gunma=5m", "all=1h"} {
+		s, err := ParseSchedule(raw)
+		if err != nil {
+			t.Fatalf("error while parsing schedule: %v\n", err)
+		}
+		schedules = append(schedules, s)
+	}
+	expected := []Schedule{
+		{Leaderboard: "gunma", CurrentMonth: true, Interval: "5m"},
+		{Leaderboard: "all", Interval: "1h"},
+	}
+	if !slices.Equal(schedules, expected) {
+		t.Fatalf("expected %v, got %v\n", expected, schedules)
+	}
+
+	for _, invalid := range []string{"gunma", "=5m", "gunma=soon"} {
+		if _, err := ParseSchedule(invalid); err == nil {
+			t.Fatalf("%q should not be a valid schedule\n", invalid)
+		}
+	}
+}
+
+func TestParseNotifier(t *testing.T) {
+	n, err := ParseNotifier("discord=https://discord.com/api/webhooks/1/abc")
+	if err != nil || n.Type != "discord" || n.WebhookURL != "https://discord.com/api/webhooks/1/abc" {
+		t.Fatalf("unexpected notifier %v: %v\n", n, err)
+	}
+	if _, err := ParseNotifier("discord"); err == nil {
+		t.Fatal("a notifier without a webhook url should be rejected")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	dir := t.TempDir()
+	file := `{"kbt_base_url": "http://file", "top_n": 5, "store": {"backend": "memory"}}`
+	if err := os.WriteFile(filepath.Join(dir, CONFIG_FILE), []byte(file), 0644); err != nil {
+		t.Fatalf("error while writing config: %v\n", err)
+	}
+
+	secret := "https://discord.com/api/webhooks/1/secret"
+	t.Setenv("KAIDO_TOP_N", "10")
+	t.Setenv("KAIDO_DISCORD_WEBHOOK_URL", secret)
+	t.Setenv("KAIDO_EVENTS", "new_record,podium")
+	// the workspace is only picked with --workdir or KAIDO_HOME
+	t.Setenv("KAIDO_WORKSPACE_PATH", t.TempDir())
+
+	cfg, err := Load("", filepath.Join(dir, CONFIG_FILE), "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if err := cfg.Override("store.backend", []string{"log"}, SOURCE_FLAG); err != nil {
+		t.Fatalf("error while overriding setting: %v\n", err)
+	}
+
+	expected := map[string]Source{
+		"kbt_base_url":        SOURCE_FILE,
+		"top_n":               SOURCE_ENV,
+		"discord_webhook_url": SOURCE_ENV,
+		"events":              SOURCE_ENV,
+		"store.backend":       SOURCE_FLAG,
+		"refresh_interval":    SOURCE_DEFAULT,
+	}
+	for key, source := range expected {
+		if got := cfg.Source(key); got != source {
+			t.Fatalf("expected %s to come from %s, got %s\n", key, source, got)
+		}
+	}
+	if cfg.WorkspacePath != dir {
+		t.Fatalf("workspace should not be taken from the environment, got %s\n", cfg.WorkspacePath)
+	}
+	if cfg.TopN != 10 || cfg.Store.Backend != "log" || !slices.Equal(cfg.Events, []string{"new_record", "podium"}) {
+		t.Fatalf("overrides were not applied: %+v\n", cfg)
+	}
+	if v, _ := cfg.Value("discord_webhook_url", false); strings.Contains(v, "secret") {
+		t.Fatalf("webhook url should be masked, got %s\n", v)
+	}
+
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if err != nil {
+		t.Fatalf("error while reading config: %v\n", err)
+	}
+	if strings.Contains(string(saved), "secret") {
+		t.Fatalf("webhook url from the environment should not be saved:\n%s\n", saved)
+	}
+	if !strings.Contains(string(saved), `"top_n": 5`) || !strings.Contains(string(saved), `"backend": "memory"`) {
+		t.Fatalf("overrides should not replace the file values:\n%s\n", saved)
+	}
+
+	cfg.Persist(SOURCE_FLAG)
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+	saved, _ = os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if !strings.Contains(string(saved), `"backend": "log"`) {
+		t.Fatalf("persisted flag should be saved:\n%s\n", saved)
+	}
+
+	// --workdir picks the workspace, it is not pinned in the file
+	cfg, err = Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	cfg.Persist(SOURCE_FLAG)
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+	saved, _ = os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if strings.Contains(string(saved), "workspace_path") {
+		t.Fatalf("workspace should not be persisted:\n%s\n", saved)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	file := `{"workspace_path": "` + dir + `", "kbt_base_url": "http://5.161.130.32:8000/", "leaderboards": null, "discord_webhook_url": ""}`
+	if err := os.WriteFile(filepath.Join(dir, CONFIG_FILE), []byte(file), 0644); err != nil {
+		t.Fatalf("error while writing config: %v\n", err)
+	}
+
+	cfg, err := Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if cfg.Version != CONFIG_VERSION || cfg.KBTBaseUrl != DEFAULT_KBT_BASE_URL {
+		t.Fatalf("config was not migrated: %+v\n", cfg)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if err != nil {
+		t.Fatalf("error while reading config: %v\n", err)
+	}
+	if !strings.Contains(string(saved), `"version": 2`) || strings.Contains(string(saved), "workspace_path") || !strings.Contains(string(saved), `"profiles"`) {
+		t.Fatalf("migrated config was not written:\n%s\n", saved)
+	}
+	if backup, err := os.ReadFile(filepath.Join(dir, CONFIG_FILE+".v0.bak")); err != nil || string(backup) != file {
+		t.Fatalf("old config should be kept: %v\n", err)
+	}
+
+	for _, invalid := range []string{`{"version": 99}`, `{"version": "1"}`, `{"version": 2, "top_m": 3}`, `{"version": 2, "kbt_base_url": "http://kbt"}`} {
+		if _, err := Parse([]byte(invalid), filepath.Join(dir, CONFIG_FILE)); err == nil {
+			t.Fatalf("%s should not be a valid config\n", invalid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	leaderboards := models.Leaderboards{"gunma": {Region: "gunma"}, "akina": {Region: "akina"}}
+	tests := []struct {
+		name   string
+		cfg    Profile
+		errMsg string
+	}{
+		{"valid", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, DiscordWebhookURL: "https://discord.com/api/webhooks/1/abc-D_e"}, ""},
+		{"empty base url", Profile{}, "kbt_base_url: url cannot be empty"},
+		{"base url scheme", Profile{KBTBaseUrl: "5.161.130.32:8000"}, "must start with http:// or https://"},
+		{"base url slash", Profile{KBTBaseUrl: "http://kbt/"}, "must not end with a slash"},
+		{"base url query", Profile{KBTBaseUrl: "http://kbt?a=1"}, "must not have a query"},
+		{"webhook host", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, DiscordWebhookURL: "https://example.com/api/webhooks/1/abc"}, "discord_webhook_url: \"https://example.com/****\" is not a discord webhook"},
+		{"webhook path", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, DiscordWebhookURL: "https://discord.com/channels/1"}, "the path must be /api/webhooks/<id>/<token>"},
+		{"notifier", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Notifiers: []Notifier{{Type: "discord"}}}, "notifiers[0]: discord notifier needs a webhook url"},
+		{"region", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Leaderboards: leaderboards, Schedules: []Schedule{{Leaderboard: "akina,gumna", Interval: "5m"}}}, `schedules[0]: unknown leaderboard region "gumna", known regions are all, akina, gunma`},
+		{"route webhook", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Routes: []Route{{Webhooks: []string{"https://discord.com/api/webhooks/1/abc", "http://example.com/hook"}}}}, `routes[0]: webhooks[1]: "http://example.com/****" must use https`},
+		{"route without webhook", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Routes: []Route{{Region: "gunma"}}}, "routes[0]: route needs at least one webhook"},
+		{"route event", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Routes: []Route{{Events: []string{"record"}, Webhooks: []string{"https://discord.com/api/webhooks/1/abc"}}}}, `routes[0]: unknown event "record", known events are all_time_record, monthly_record, podium`},
+		{"route region", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Leaderboards: leaderboards, Routes: []Route{{Region: "usui", Webhooks: []string{"https://discord.com/api/webhooks/1/abc"}}}}, `routes[0]: unknown leaderboard region "usui", known leaderboard regions are akina, gunma`},
+		{"undiscovered region", Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL, Schedules: []Schedule{{Leaderboard: "gumna", Interval: "5m"}}}, ""},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Profiles: map[string]*Profile{DEFAULT_PROFILE: &tt.cfg}}
+		cfg.Use(DEFAULT_PROFILE)
+		err := cfg.Validate()
+		if len(tt.errMsg) == 0 {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v\n", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Fatalf("%s: expected error containing %q, got %v\n", tt.name, tt.errMsg, err)
+		}
+	}
+
+	cfg := Config{Profiles: map[string]*Profile{DEFAULT_PROFILE: {KBTBaseUrl: DEFAULT_KBT_BASE_URL}}, Events: []string{"new_record", "rank_drop"}}
+	cfg.Use(DEFAULT_PROFILE)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `events: unknown event "rank_drop"`) {
+		t.Fatalf("expected unknown event to be rejected, got %v\n", err)
+	}
+}
+
+func TestSetUnset(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("KAIDO_TOP_N", "10")
+	cfg, err := Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+
+	if err := cfg.Set("top_n", []string{"3"}); err != nil {
+		t.Fatalf("error while setting top_n: %v\n", err)
+	}
+	if err := cfg.Set("kbt_base_url", []string{"http://kbt"}); err != nil {
+		t.Fatalf("error while setting kbt_base_url: %v\n", err)
+	}
+	if err := cfg.Set("top_m", []string{"3"}); err == nil {
+		t.Fatal("unknown settings should be rejected")
+	}
+	if cfg.TopN != 3 || cfg.Source("top_n") != SOURCE_FILE {
+		t.Fatalf("set should take over the environment, got %d from %s\n", cfg.TopN, cfg.Source("top_n"))
+	}
+
+	if err := cfg.Unset("kbt_base_url"); err != nil {
+		t.Fatalf("error while unsetting kbt_base_url: %v\n", err)
+	}
+	if cfg.KBTBaseUrl != DEFAULT_KBT_BASE_URL || cfg.Source("kbt_base_url") != SOURCE_DEFAULT {
+		t.Fatalf("unset should restore the default, got %s\n", cfg.KBTBaseUrl)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+
+	cfg, err = Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if cfg.TopN != 10 || cfg.base.TopN != 3 {
+		t.Fatalf("expected top_n 3 in the file and 10 from the environment, got %d and %d\n", cfg.base.TopN, cfg.TopN)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	file := `{"version": 2, "default_profile": "staging", "profiles": {
+		"default": {"kbt_base_url": "http://production"},
+		"staging": {"namespace": "staging", "kbt_base_url": "http://staging"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, CONFIG_FILE), []byte(file), 0644); err != nil {
+		t.Fatalf("error while writing config: %v\n", err)
+	}
+
+	cfg, err := Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if cfg.ActiveProfile() != "staging" || cfg.ExplicitProfile() || cfg.KBTBaseUrl != "http://staging" || cfg.Namespace != "staging" {
+		t.Fatalf("expected the default profile of the config, got %s %s\n", cfg.ActiveProfile(), cfg.KBTBaseUrl)
+	}
+
+	t.Setenv(PROFILE_ENV, "default")
+	t.Setenv("KAIDO_KBT_BASE_URL", "http://override")
+	cfg, err = Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if cfg.ActiveProfile() != DEFAULT_PROFILE || !cfg.ExplicitProfile() || cfg.KBTBaseUrl != "http://override" {
+		t.Fatalf("expected the default profile with the override, got %s %s\n", cfg.ActiveProfile(), cfg.KBTBaseUrl)
+	}
+
+	staging, err := cfg.For("staging")
+	if err != nil || staging.KBTBaseUrl != "http://staging" || staging.Source("kbt_base_url") != SOURCE_DEFAULT {
+		t.Fatalf("override of the profile in use should not apply to other profiles: %v\n", err)
+	}
+
+	// discovery saves through the view of the profile it runs for
+	staging.Leaderboards = models.Leaderboards{}
+	if err := staging.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if err != nil {
+		t.Fatalf("error while reading config: %v\n", err)
+	}
+	if strings.Contains(string(saved), "override") || !strings.Contains(string(saved), `"leaderboards": {}`) {
+		t.Fatalf("saving a view should keep the overrides of the profile in use off the disk:\n%s\n", saved)
+	}
+
+	// a new profile is created on the first save
+	cfg, err = Load(dir, "", "usui")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if cfg.HasProfile("usui") || cfg.Namespace != "usui" {
+		t.Fatalf("new profile should get its own namespace and not exist before it is saved\n")
+	}
+	cfg.DiscordWebhookURL = "https://discord.com/api/webhooks/1/abc"
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("error while saving config: %v\n", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v\n", err)
+	}
+
+	cfg, err = Load(dir, "", "")
+	if err != nil {
+		t.Fatalf("error while loading config: %v\n", err)
+	}
+	if !slices.Equal(cfg.ProfileNames(), []string{"default", "staging", "usui"}) {
+		t.Fatalf("unexpected profiles %v\n", cfg.ProfileNames())
+	}
+	saved, err = os.ReadFile(filepath.Join(dir, CONFIG_FILE))
+	if err != nil {
+		t.Fatalf("error while reading config: %v\n", err)
+	}
+	if strings.Contains(string(saved), "override") {
+		t.Fatalf("override from the environment should not be saved:\n%s\n", saved)
+	}
+
+	cfg.Profiles["usui"].Namespace = "staging"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `profiles.usui.namespace: profile staging already uses the store namespace "staging"`) {
+		t.Fatalf("expected a namespace conflict, got %v\n", err)
+	}
+
+	if _, err := Load(dir, "", "not/a/profile"); err == nil {
+		t.Fatal("invalid profile names should be rejected")
+	}
+}
+
+func TestUnreadableFile(t *testing.T) {
+	dir := t.TempDir()
+	file := `{"version": 2, "discord_webhok_url": "typo", "profiles": {"default": {}}}`
+	if err := os.WriteFile(filepath.Join(dir, CONFIG_FILE), []byte(file), 0644); err != nil {
+		t.Fatalf("error while writing config: %v\n", err)
+	}
+
+	cfg, err := Load(dir, "", "")
+	if !errors.Is(err, ERR_INVALID_FILE) || cfg == nil {
+		t.Fatalf("expected the file to be reported as invalid, got %v\n", err)
+	}
+	if cfg.Loaded() || cfg.ReadError() == nil || cfg.Path() != filepath.Join(dir, CONFIG_FILE) {
+		t.Fatalf("the config should point at the file it could not read\n")
+	}
+}
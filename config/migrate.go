@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CONFIG_VERSION is the version of the config written by Save, older files are migrated on Load
+	CONFIG_VERSION = 2
+)
+
+// migrations[i] upgrades a config of version i to version i+1. They work on the raw json so fields
+// that were renamed or moved can still be read, dir is the directory of the config file.
+var migrations = []func(raw map[string]any, dir string){
+	// 0 -> 1
+	func(raw map[string]any, dir string) {
+		// the workspace defaults to the directory of the config, pinning it there stops the
+		// workspace from moving along with the config
+		if ws, ok := raw["workspace_path"].(string); ok && (len(ws) == 0 || filepath.Clean(ws) == filepath.Clean(dir)) {
+			delete(raw, "workspace_path")
+		}
+		// links of the server are appended to the base url
+		if base, ok := raw["kbt_base_url"].(string); ok {
+			raw["kbt_base_url"] = strings.TrimRight(base, "/")
+		}
+	},
+	// 1 -> 2
+	func(raw map[string]any, dir string) {
+		// the single server becomes the default profile
+		profile := make(map[string]any)
+		for _, key := range []string{"kbt_base_url", "leaderboards", "discord_webhook_url", "notifiers", "schedules"} {
+			if v, exists := raw[key]; exists {
+				profile[key] = v
+				delete(raw, key)
+			}
+		}
+		raw["profiles"] = map[string]any{DEFAULT_PROFILE: profile}
+	},
+}
+
+// migrate upgrades the encoded config to CONFIG_VERSION, the version it had is returned along with it.
+func migrate(encoded []byte, dir string) ([]byte, int, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if v, exists := raw["version"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return nil, 0, fmt.Errorf("version must be a whole number, got %v", v)
+		}
+		version = int(n)
+	}
+	if version > CONFIG_VERSION {
+		return nil, version, fmt.Errorf("config version %d is newer than this kaido supports (%d), upgrade kaido", version, CONFIG_VERSION)
+	}
+	if version == CONFIG_VERSION {
+		return encoded, version, nil
+	}
+
+	for v := version; v < CONFIG_VERSION; v++ {
+		migrations[v](raw, dir)
+	}
+	raw["version"] = CONFIG_VERSION
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, version, err
+	}
+	return migrated, version, nil
+}
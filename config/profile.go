@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/dimfu/kaido/models"
+)
+
+const (
+	PROFILE_ENV     = "KAIDO_PROFILE"
+	DEFAULT_PROFILE = "default"
+)
+
+var (
+	profileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Profile is everything tied to one KBT server.
+type Profile struct {
+	// Namespace keeps the records of the profile apart in the store, empty uses the root of the store
+	Namespace         string              `json:"namespace,omitempty"`
+	KBTBaseUrl        string              `json:"kbt_base_url"`
+	Leaderboards      models.Leaderboards `json:"leaderboards"`
+	DiscordWebhookURL string              `json:"discord_webhook_url"`
+	Notifiers         []Notifier          `json:"notifiers,omitempty"`
+	Schedules         []Schedule          `json:"schedules,omitempty"`
+	Routes            []Route             `json:"routes,omitempty"`
+}
+
+// newProfile is an empty profile, the default profile keeps the root of the store so records
+// collected before profiles existed stay where they are.
+func newProfile(name string) *Profile {
+	p := &Profile{KBTBaseUrl: DEFAULT_KBT_BASE_URL}
+	if name != DEFAULT_PROFILE {
+		p.Namespace = name
+	}
+	return p
+}
+
+// Use makes the named profile the one in use, an empty name is the default profile. A profile
+// that does not exist yet is created on the next Save.
+func (c *Config) Use(name string) error {
+	if len(name) == 0 {
+		name = DEFAULT_PROFILE
+	}
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, only letters, digits, - and _ are allowed", name)
+	}
+
+	c.active = name
+	if p, exists := c.Profiles[name]; exists {
+		c.Profile = p
+	} else {
+		c.Profile = newProfile(name)
+	}
+	return nil
+}
+
+// ActiveProfile is the name of the profile in use.
+func (c *Config) ActiveProfile() string {
+	return c.active
+}
+
+// ExplicitProfile reports whether the profile in use was picked with --profile or KAIDO_PROFILE.
+func (c *Config) ExplicitProfile() bool {
+	return c.explicit
+}
+
+// HasProfile reports whether the named profile is saved in the config.
+func (c *Config) HasProfile(name string) bool {
+	_, exists := c.Profiles[name]
+	return exists
+}
+
+// ProfileNames lists the saved profiles sorted by name.
+func (c *Config) ProfileNames() []string {
+	return slices.Sorted(maps.Keys(c.Profiles))
+}
+
+// For is a view of the config using another saved profile, settings overridden for the profile
+// in use do not apply to it. Saving the view saves the whole config.
+func (c *Config) For(name string) (*Config, error) {
+	if c.root != nil {
+		return c.root.For(name)
+	}
+	p, exists := c.Profiles[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+	if name == c.active {
+		return c, nil
+	}
+	view := *c
+	view.active, view.Profile = name, p
+	view.root = c
+	view.sources, view.overrides = make(map[string]Source), make(map[string]any)
+	for _, s := range Settings() {
+		if s.profile {
+			continue
+		}
+		if src, ok := c.sources[s.Key]; ok {
+			view.sources[s.Key] = src
+		}
+		if v, ok := c.overrides[s.Key]; ok {
+			view.overrides[s.Key] = v
+		}
+	}
+	return &view, nil
+}
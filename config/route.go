@@ -0,0 +1,24 @@
+package config
+
+const (
+	ROUTE_ALL_TIME_RECORD = "all_time_record"
+	ROUTE_MONTHLY_RECORD  = "monthly_record"
+	ROUTE_PODIUM          = "podium"
+)
+
+var (
+	// RouteEvents are the event types a route can be limited to
+	RouteEvents = []string{ROUTE_ALL_TIME_RECORD, ROUTE_MONTHLY_RECORD, ROUTE_PODIUM}
+)
+
+// Route sends the announcements it matches to its own discord webhooks. Every matching route gets the
+// announcement, the ones no route matches go to discord_webhook_url and the notifiers.
+type Route struct {
+	// Region, Track and Stage narrow the route down, empty matches any
+	Region string `json:"region,omitempty"`
+	Track  string `json:"track,omitempty"`
+	Stage  string `json:"stage,omitempty"`
+	// Events limits the route to some of RouteEvents, empty matches every announcement
+	Events   []string `json:"events,omitempty"`
+	Webhooks []string `json:"webhooks"`
+}
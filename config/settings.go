@@ -0,0 +1,391 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source is the layer a setting got its value from, later layers win.
+type Source string
+
+const (
+	SOURCE_DEFAULT Source = "default"
+	SOURCE_FILE    Source = "file"
+	SOURCE_ENV     Source = "env"
+	SOURCE_FLAG    Source = "flag"
+
+	ENV_PREFIX = "KAIDO_"
+)
+
+// Setting is a single value of the config that can be overridden from the environment or a flag.
+type Setting struct {
+	// Key is the json path of the setting eg; store.backend
+	Key   string
+	Usage string
+	index []int
+	typ   reflect.Type
+	// profile is set for the settings of a profile, they apply to the profile in use
+	profile bool
+}
+
+// Env is the environment variable overriding the setting eg; KAIDO_STORE_BACKEND.
+func (s Setting) Env() string {
+	return ENV_PREFIX + strings.ToUpper(s.Flag())
+}
+
+// Flag is the name of the global flag overriding the setting eg; store_backend.
+func (s Setting) Flag() string {
+	return strings.ReplaceAll(s.Key, ".", "_")
+}
+
+// Overridable reports whether the setting gets a global flag and environment variable, the workspace is
+// picked with --workdir or KAIDO_HOME instead.
+func (s Setting) Overridable() bool {
+	return !slices.Contains(fileOnly, s.Key)
+}
+
+// Multiple reports whether the setting is a list, lists take one value per flag and comma separated values from env.
+func (s Setting) Multiple() bool {
+	return s.typ.Kind() == reflect.Slice
+}
+
+var usages = map[string]string{
+	"workspace_path":          "directory holding the store",
+	"kbt_base_url":            "url of the KBT server of the profile",
+	"discord_webhook_url":     "discord webhook records of the profile are announced to",
+	"notifiers":               "extra destination as type=webhook_url, eg; discord=https://discord.com/api/webhooks/...",
+	"schedules":               "watch schedule as [month:]leaderboard=interval, eg; month:gunma=5m",
+	"top_n":                   "how many places of each leaderboard are watched for changes",
+	"events":                  "kinds of changes that are announced",
+	"refresh_interval":        "how often the watcher re-discovers leaderboards, tracks and stages, disabled by default",
+	"history.max_age_days":    "drop leaderboard snapshots older than this on compaction",
+	"history.max_versions":    "keep at most this many snapshots of a leaderboard on compaction",
+	"store.backend":           "log or memory",
+	"store.compact_threshold": "share of wasted space (0-1) that triggers compaction",
+	"store.max_segment_mb":    "size of a store file before writes move on to a new one",
+}
+
+// not settings: the file format, the profiles, routes and discovered data only ever come from the file
+var skipped = []string{"version", "default_profile", "profiles", "namespace", "leaderboards", "routes"}
+
+// settings that are only read from the file, they have no flag or environment variable of their own
+var fileOnly = []string{"workspace_path"}
+
+// Settings lists every setting of the config in declaration order, the settings of the profile in use first.
+func Settings() []Setting {
+	return settings(reflect.TypeOf(Config{}), "", nil, false)
+}
+
+func settings(t reflect.Type, prefix string, index []int, profile bool) []Setting {
+	var result []Setting
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type == reflect.TypeOf(&Profile{}) {
+			result = append(result, settings(field.Type.Elem(), "", nil, true)...)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || len(name) == 0 {
+			continue
+		}
+		key := prefix + name
+		if slices.Contains(skipped, key) {
+			continue
+		}
+
+		idx := append(slices.Clone(index), i)
+		if field.Type.Kind() == reflect.Struct {
+			result = append(result, settings(field.Type, key+".", idx, profile)...)
+			continue
+		}
+		result = append(result, Setting{Key: key, Usage: usages[key], index: idx, typ: field.Type, profile: profile})
+	}
+	return result
+}
+
+// LookupSetting finds the setting with the given key.
+func LookupSetting(key string) (Setting, bool) {
+	for _, s := range Settings() {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+func (s Setting) value(c *Config) reflect.Value {
+	if s.profile {
+		return reflect.ValueOf(c.Profile).Elem().FieldByIndex(s.index)
+	}
+	return reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+}
+
+// parse turns raw values into a value of the setting, lists take every value and the rest only the last one.
+func (s Setting) parse(values []string) (reflect.Value, error) {
+	if !s.Multiple() {
+		if len(values) == 0 {
+			return reflect.Value{}, fmt.Errorf("%s needs a value", s.Key)
+		}
+		return parseValue(s.typ, values[len(values)-1])
+	}
+
+	list := reflect.MakeSlice(s.typ, 0, len(values))
+	for _, raw := range values {
+		v, err := parseValue(s.typ.Elem(), raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		list = reflect.Append(list, v)
+	}
+	return list, nil
+}
+
+func parseValue(t reflect.Type, raw string) (reflect.Value, error) {
+	raw = strings.TrimSpace(raw)
+	v := reflect.New(t).Elem()
+	switch t {
+	case reflect.TypeOf(Notifier{}):
+		n, err := ParseNotifier(raw)
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.ValueOf(n))
+		return v, nil
+	case reflect.TypeOf(Schedule{}):
+		s, err := ParseSchedule(raw)
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.ValueOf(s))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return v, fmt.Errorf("%q is not true or false", raw)
+		}
+		v.SetBool(b)
+	default:
+		if err := json.Unmarshal([]byte(raw), v.Addr().Interface()); err != nil {
+			return v, fmt.Errorf("%q is not valid json: %v", raw, err)
+		}
+	}
+	return v, nil
+}
+
+// Override sets a setting from a layer above the config file. Overridden values are not saved
+// unless they are changed afterwards or persisted.
+func (c *Config) Override(key string, values []string, source Source) error {
+	s, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	v, err := s.parse(values)
+	if err != nil {
+		return fmt.Errorf("invalid %s from %s: %v", key, source, err)
+	}
+	s.value(c).Set(v)
+
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	if c.overrides == nil {
+		c.overrides = make(map[string]any)
+	}
+	c.sources[key] = source
+	c.overrides[key] = v.Interface()
+	return nil
+}
+
+// Set changes a setting in the config file, it takes effect over the environment and flags for the rest of the run.
+func (c *Config) Set(key string, values []string) error {
+	s, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	v, err := s.parse(values)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	s.value(c).Set(v)
+	c.own(key, SOURCE_FILE)
+	return nil
+}
+
+// Unset removes a setting from the config file, it goes back to its default.
+func (c *Config) Unset(key string) error {
+	s, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	d := defaults()
+	s.value(c).Set(s.value(&d))
+	c.own(key, SOURCE_DEFAULT)
+	return nil
+}
+
+// own hands a setting to the file, or back to the defaults, so Save writes its current value.
+func (c *Config) own(key string, source Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	delete(c.overrides, key)
+	if source == SOURCE_DEFAULT {
+		delete(c.sources, key)
+		return
+	}
+	c.sources[key] = source
+}
+
+// applyEnv overrides every setting that has a KAIDO_ environment variable.
+func (c *Config) applyEnv() error {
+	for _, s := range Settings() {
+		if !s.Overridable() {
+			continue
+		}
+		raw, ok := os.LookupEnv(s.Env())
+		if !ok {
+			continue
+		}
+		values := []string{raw}
+		if s.Multiple() {
+			values = strings.Split(raw, ",")
+			if len(strings.TrimSpace(raw)) == 0 {
+				values = nil
+			}
+		}
+		if err := c.Override(s.Key, values, SOURCE_ENV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Persist makes the values that came from source part of the config file on the next Save. The workspace
+// is left out, a config pinned to its workspace keeps using that store when copied elsewhere.
+func (c *Config) Persist(source Source) {
+	for key, src := range c.sources {
+		if src == source && !slices.Contains(fileOnly, key) {
+			c.own(key, SOURCE_FILE)
+		}
+	}
+}
+
+// Source tells which layer the current value of a setting comes from.
+func (c *Config) Source(key string) Source {
+	if src, ok := c.sources[key]; ok {
+		return src
+	}
+	return SOURCE_DEFAULT
+}
+
+// Value formats the current value of a setting, webhook urls are masked unless reveal is set.
+func (c *Config) Value(key string, reveal bool) (string, error) {
+	s, ok := LookupSetting(key)
+	if !ok {
+		return "", fmt.Errorf("unknown setting %s", key)
+	}
+	v := s.value(c)
+	format := func(v reflect.Value) string {
+		switch x := v.Interface().(type) {
+		case Notifier:
+			if !reveal {
+				x.WebhookURL = maskURL(x.WebhookURL)
+			}
+			return x.String()
+		case Schedule:
+			return x.String()
+		case string:
+			if !reveal && strings.Contains(key, "webhook") {
+				return maskURL(x)
+			}
+			return x
+		}
+		return fmt.Sprint(v.Interface())
+	}
+
+	if !s.Multiple() {
+		return format(v), nil
+	}
+	values := make([]string, 0, v.Len())
+	for i := range v.Len() {
+		values = append(values, format(v.Index(i)))
+	}
+	return strings.Join(values, ","), nil
+}
+
+// maskURL hides everything after the host, webhook urls carry their token in the path.
+func maskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Host) == 0 {
+		if len(raw) == 0 {
+			return raw
+		}
+		return "****"
+	}
+	if len(u.Path) > 1 || len(u.RawQuery) > 0 {
+		return u.Scheme + "://" + u.Host + "/****"
+	}
+	return raw
+}
+
+func (n Notifier) String() string {
+	return n.Type + "=" + n.WebhookURL
+}
+
+func (s Schedule) String() string {
+	if s.CurrentMonth {
+		return "month:" + s.Leaderboard + "=" + s.Interval
+	}
+	return s.Leaderboard + "=" + s.Interval
+}
+
+// ParseNotifier reads a notifier written as type=webhook_url.
+func ParseNotifier(v string) (Notifier, error) {
+	kind, url, found := strings.Cut(v, "=")
+	if !found || len(kind) == 0 || len(url) == 0 {
+		return Notifier{}, fmt.Errorf("notifier must be type=webhook_url, got %q", v)
+	}
+	return Notifier{Type: kind, WebhookURL: url}, nil
+}
+
+// ParseSchedule reads a schedule written as leaderboard=interval, a month: prefix
+// polls the current month leaderboard instead.
+func ParseSchedule(v string) (Schedule, error) {
+	leaderboard, interval, found := strings.Cut(v, "=")
+	if !found || len(leaderboard) == 0 {
+		return Schedule{}, fmt.Errorf("schedule must be [month:]leaderboard=interval, got %q", v)
+	}
+	if _, err := time.ParseDuration(interval); err != nil {
+		return Schedule{}, fmt.Errorf("invalid interval for %s schedule: %v", leaderboard, err)
+	}
+	leaderboard, currentMonth := strings.CutPrefix(leaderboard, "month:")
+	return Schedule{
+		Leaderboard:  leaderboard,
+		CurrentMonth: currentMonth,
+		Interval:     interval,
+	}, nil
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// hosts discord serves webhooks from
+	discordHosts = []string{"discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com"}
+	// path of a discord webhook, /api/webhooks/<id>/<token>
+	discordWebhookPath = regexp.MustCompile(`^/api(/v\d+)?/webhooks/\d+/[\w-]+/?$`)
+)
+
+// Validate checks the settings that would otherwise only fail once kaido talks to the server or a
+// webhook. Every problem is reported on its own line, prefixed with the setting it is about.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+		}
+	}
+
+	if len(c.DefaultProfile) > 0 && !c.HasProfile(c.DefaultProfile) {
+		check("default_profile", fmt.Errorf("unknown profile %q, known profiles are %s", c.DefaultProfile, strings.Join(c.ProfileNames(), ", ")))
+	}
+
+	for _, e := range c.Events {
+		if !slices.Contains(EventKinds, e) {
+			check("events", fmt.Errorf("unknown event %q, known events are %s", e, strings.Join(EventKinds, ", ")))
+		}
+	}
+
+	names := c.ProfileNames()
+	if c.Profile != nil && !c.HasProfile(c.active) {
+		names = append(names, c.active)
+	}
+	namespaces := make(map[string]string)
+	for _, name := range names {
+		// the profile in use is checked with its overrides, its settings are named as they are set
+		p, prefix := c.Profiles[name], "profiles."+name+"."
+		if name == c.active {
+			p, prefix = c.Profile, ""
+		}
+		if !profileName.MatchString(name) {
+			check("profiles", fmt.Errorf("invalid profile name %q, only letters, digits, - and _ are allowed", name))
+		}
+
+		if len(p.Namespace) > 0 && !profileName.MatchString(p.Namespace) {
+			check(prefix+"namespace", fmt.Errorf("invalid namespace %q, only letters, digits, - and _ are allowed", p.Namespace))
+		}
+		if other, used := namespaces[p.Namespace]; used {
+			check(prefix+"namespace", fmt.Errorf("profile %s already uses the store namespace %q", other, p.Namespace))
+		}
+		namespaces[p.Namespace] = name
+
+		check(prefix+"kbt_base_url", validateBaseURL(p.KBTBaseUrl))
+		if len(p.DiscordWebhookURL) > 0 {
+			check(prefix+"discord_webhook_url", validateWebhook("discord", p.DiscordWebhookURL))
+		}
+		for i, n := range p.Notifiers {
+			check(fmt.Sprintf("%snotifiers[%d]", prefix, i), validateWebhook(n.Type, n.WebhookURL))
+		}
+		for i, s := range p.Schedules {
+			check(fmt.Sprintf("%sschedules[%d]", prefix, i), p.validateSchedule(s))
+		}
+		for i, r := range p.Routes {
+			check(fmt.Sprintf("%sroutes[%d]", prefix, i), p.validateRoute(r))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateURL checks raw is an absolute http(s) url, shown is how raw appears in the error.
+func validateURL(raw, shown string) (*url.URL, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("url cannot be empty")
+	}
+	if !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+		return nil, fmt.Errorf("%q must start with http:// or https://", shown)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a url", shown)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("%q has no host", shown)
+	}
+	return u, nil
+}
+
+func validateBaseURL(raw string) error {
+	u, err := validateURL(raw, raw)
+	if err != nil {
+		return err
+	}
+	// links of the server are appended to the base url as is
+	if len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		return fmt.Errorf("%q must not end with a slash, eg; %s", raw, DEFAULT_KBT_BASE_URL)
+	}
+	return nil
+}
+
+func validateWebhook(kind, raw string) error {
+	if kind != "discord" {
+		if len(raw) == 0 {
+			return nil
+		}
+		_, err := validateURL(raw, maskURL(raw))
+		return err
+	}
+
+	if len(raw) == 0 {
+		return errors.New("discord notifier needs a webhook url")
+	}
+	u, err := validateURL(raw, maskURL(raw))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%q must use https", maskURL(raw))
+	}
+	if !slices.Contains(discordHosts, u.Hostname()) {
+		return fmt.Errorf("%q is not a discord webhook, the host must be one of %s", maskURL(raw), strings.Join(discordHosts, ", "))
+	}
+	if !discordWebhookPath.MatchString(u.Path) {
+		return fmt.Errorf("%q is not a discord webhook, the path must be /api/webhooks/<id>/<token>", maskURL(raw))
+	}
+	return nil
+}
+
+func (p *Profile) validateRoute(r Route) error {
+	if len(r.Webhooks) == 0 {
+		return errors.New("route needs at least one webhook")
+	}
+	for i, webhook := range r.Webhooks {
+		if err := validateWebhook("discord", webhook); err != nil {
+			return fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+	}
+	for _, e := range r.Events {
+		if !slices.Contains(RouteEvents, e) {
+			return fmt.Errorf("unknown event %q, known events are %s", e, strings.Join(RouteEvents, ", "))
+		}
+	}
+	if len(r.Stage) > 0 && len(r.Track) == 0 {
+		return fmt.Errorf("stage %q needs a track, stage names are not unique across tracks", r.Stage)
+	}
+
+	// tracks and stages are only known once the leaderboards were discovered
+	if p.Leaderboards == nil {
+		return nil
+	}
+	var regions, tracks, stages []string
+	for region, leaderboard := range p.Leaderboards {
+		regions = append(regions, region)
+		if len(r.Region) > 0 && !strings.EqualFold(region, r.Region) {
+			continue
+		}
+		for _, track := range leaderboard.Tracks {
+			tracks = append(tracks, track.Name)
+			if !strings.EqualFold(track.Name, r.Track) {
+				continue
+			}
+			for _, stage := range track.Stages {
+				stages = append(stages, stage.Name)
+			}
+		}
+	}
+	known := func(kind, name string, names []string) error {
+		if len(name) == 0 || slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+			return nil
+		}
+		slices.Sort(names)
+		return fmt.Errorf("unknown %s %q, known %ss are %s", kind, name, kind, strings.Join(slices.Compact(names), ", "))
+	}
+	if err := known("leaderboard region", r.Region, regions); err != nil {
+		return err
+	}
+	if err := known("track", r.Track, tracks); err != nil {
+		return err
+	}
+	return known("stage", r.Stage, stages)
+}
+
+func (p *Profile) validateSchedule(s Schedule) error {
+	if _, err := ParseSchedule(s.String()); err != nil {
+		return err
+	}
+	// regions are only known once the leaderboards were discovered
+	if p.Leaderboards == nil {
+		return nil
+	}
+
+	known := make([]string, 0, len(p.Leaderboards))
+	for region := range p.Leaderboards {
+		known = append(known, region)
+	}
+	slices.Sort(known)
+
+	for _, region := range strings.Split(strings.ToLower(s.Leaderboard), ",") {
+		region = strings.TrimSpace(region)
+		if region == "all" || slices.Contains(known, region) {
+			continue
+		}
+		return fmt.Errorf("unknown leaderboard region %q, known regions are all, %s", region, strings.Join(known, ", "))
+	}
+	return nil
+}
@@ -3,13 +3,18 @@ package discord
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dimfu/kaido/config"
 )
@@ -22,10 +27,15 @@ func Prompt(webhookUrl ...string) error {
 		r := bufio.NewReader(os.Stdin)
 		for {
 			fmt.Fprint(os.Stderr, "Enter your discord webhook url: ")
-			s, _ = r.ReadString('\n')
-			if s != "" {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				s = line
 				break
 			}
+			// a closed stdin keeps returning nothing
+			if err != nil {
+				return fmt.Errorf("cannot read the webhook url: %v", err)
+			}
 		}
 	} else {
 		s = webhookUrl[0]
@@ -65,27 +75,194 @@ func Prompt(webhookUrl ...string) error {
 	return nil
 }
 
-func Send(s, url string) error {
-	client := &http.Client{}
-	payload := map[string]string{
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Url         string       `json:"url,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+}
+
+var (
+	ERR_RETRIES_EXHAUSTED = errors.New("gave up delivering webhook after too many attempts")
+
+	defaultClient = NewClient()
+)
+
+// Client delivers webhook messages one at a time per webhook url while
+// respecting discord rate limits and retrying transient failures.
+type Client struct {
+	HTTP       *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+
+	mu    sync.Mutex
+	hooks map[string]*webhook
+}
+
+type webhook struct {
+	mu      sync.Mutex
+	resetAt time.Time
+}
+
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("discord webhook responded with %d: %s", e.StatusCode, e.Body)
+}
+
+func NewClient() *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		MaxRetries: 5,
+		Backoff:    500 * time.Millisecond,
+		hooks:      make(map[string]*webhook),
+	}
+}
+
+func Send(ctx context.Context, s, url string) error {
+	return defaultClient.Send(ctx, s, url)
+}
+
+// SendEmbeds posts up to 10 embeds in a single webhook message.
+func SendEmbeds(ctx context.Context, embeds []Embed, url string) error {
+	return defaultClient.SendEmbeds(ctx, embeds, url)
+}
+
+func (c *Client) Send(ctx context.Context, s, url string) error {
+	return c.post(ctx, map[string]any{
 		"content": s,
+	}, url)
+}
+
+func (c *Client) SendEmbeds(ctx context.Context, embeds []Embed, url string) error {
+	return c.post(ctx, map[string]any{
+		"embeds": embeds,
+	}, url)
+}
+
+func (c *Client) webhook(url string) *webhook {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hook, exists := c.hooks[url]
+	if !exists {
+		hook = &webhook{}
+		c.hooks[url] = hook
 	}
+	return hook
+}
 
+func (c *Client) post(ctx context.Context, payload map[string]any, url string) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
-	request.Header.Set("Content-Type", "application/json")
+	// requests to the same webhook share a rate limit bucket so send them in order
+	hook := c.webhook(url)
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if err := sleep(ctx, time.Until(hook.resetAt)); err != nil {
+			return err
+		}
+
+		wait, err := c.do(ctx, hook, body, url)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode < 500 {
+			return err
+		}
+
+		lastErr = err
+		if attempt == c.MaxRetries {
+			break
+		}
+		if wait == 0 {
+			wait = c.Backoff << attempt
+		}
+		fmt.Printf("discord webhook attempt %d failed, retrying in %s: %v\n", attempt+1, wait, err)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ERR_RETRIES_EXHAUSTED, lastErr)
+}
+
+// do sends a single request, the returned duration is how long discord asked us to wait before retrying.
+func (c *Client) do(ctx context.Context, hook *webhook, body []byte, url string) (time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	response, err := client.Do(request)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.HTTP.Do(request)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer response.Body.Close()
 
-	return nil
+	if response.Header.Get("X-RateLimit-Remaining") == "0" {
+		hook.resetAt = time.Now().Add(seconds(response.Header.Get("X-RateLimit-Reset-After")))
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return 0, nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	statusErr := &StatusError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(b))}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		wait := seconds(response.Header.Get("Retry-After"))
+		if wait == 0 {
+			var limited struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			if err := json.Unmarshal(b, &limited); err == nil {
+				wait = time.Duration(limited.RetryAfter * float64(time.Second))
+			}
+		}
+		return wait, statusErr
+	}
+
+	return 0, statusErr
+}
+
+func seconds(header string) time.Duration {
+	v, err := strconv.ParseFloat(header, 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return time.Duration(v * float64(time.Second))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
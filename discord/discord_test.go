@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient() *Client {
+	c := NewClient()
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func TestSendRetriesAfterRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := testClient().Send(context.Background(), "hello", server.URL); err != nil {
+		t.Fatalf("expected message to be delivered after retry: %v\n", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 requests, got %d\n", calls.Load())
+	}
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	c := testClient()
+	c.MaxRetries = 2
+	c.Backoff = 100 * time.Millisecond
+	// the backoff after the last attempt would be 400ms, giving up must not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), 650*time.Millisecond)
+	defer cancel()
+	err := c.Send(ctx, "hello", server.URL)
+	if !errors.Is(err, ERR_RETRIES_EXHAUSTED) {
+		t.Fatalf("expected retries to be exhausted, got %v\n", err)
+	}
+	if !strings.Contains(err.Error(), "502") {
+		t.Fatalf("expected the last failure in the error, got %v\n", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d\n", calls.Load())
+	}
+}
+
+func TestSendDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Cannot send an empty message"}`))
+	}))
+	defer server.Close()
+
+	err := testClient().Send(context.Background(), "", server.URL)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a 400 status error, got %v\n", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single request, got %d\n", calls.Load())
+	}
+}
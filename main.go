@@ -5,38 +5,86 @@ import (
 	"errors"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"time"
 
-	"github.com/dimfu/kaido/collectors"
 	"github.com/dimfu/kaido/commands"
+	"github.com/dimfu/kaido/commands/settings"
+	"github.com/dimfu/kaido/config"
 	"github.com/dimfu/kaido/store"
 	"github.com/urfave/cli/v3"
 )
 
-func init() {
-	if err := setup(); err != nil {
-		log.Fatalf("failed to initiate setup: %v", err)
-	}
-	if err := collectors.GenerateTimingLeaderboards(); err != nil {
-		if !errors.Is(err, collectors.ERR_ALREADY_GENERATED) {
-			log.Fatalf("cannot get leaderboard tracks data: %v", err)
-		}
+func main() {
+	cmd := &cli.Command{
+		Name:  "kaido",
+		Usage: "Collect kaido battle tour time records",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "workdir",
+				Usage: "directory holding the config and the store, default to ~/.kaido [$" + config.HOME_ENV + "]",
+			},
+			&cli.StringFlag{
+				Name:  "profile",
+				Usage: "server profile to use, default to the default_profile of the config [$" + config.PROFILE_ENV + "]",
+			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "path of the config file, default to config.json in the workdir",
+			},
+			&cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait for another running kaido to finish instead of failing",
+			},
+		}, settings.Flags()...),
+		Before: func(ctx context.Context, c *cli.Command) (context.Context, error) {
+			// nothing is prompted or fetched here, `kaido help` has to work on a fresh box
+			cfg, err := config.Load(c.String("workdir"), c.String("config"), c.String("profile"))
+			if err != nil && !(errors.Is(err, config.ERR_INVALID_FILE) && repairs(c.Args().Slice())) {
+				return ctx, err
+			}
+			if err := settings.Apply(cfg, c); err != nil {
+				return ctx, err
+			}
+
+			store.Configure(store.Options{
+				Retention: store.Retention{
+					MaxAge:      time.Duration(cfg.History.MaxAgeDays) * 24 * time.Hour,
+					MaxVersions: cfg.History.MaxVersions,
+				},
+				CompactThreshold: cfg.Store.CompactThreshold,
+				MaxSegmentSize:   int64(cfg.Store.MaxSegmentMB) << 20,
+				Wait:             c.Bool("wait"),
+				Backend:          cfg.Store.Backend,
+				Path:             filepath.Join(cfg.WorkspacePath, "store.db"),
+			})
+			return ctx, nil
+		},
+		Commands: commands.Commands(),
 	}
-}
 
-func main() {
-	store, err := store.GetInstance()
+	// the store is opened lazily by the commands that need it
+	err := cmd.Run(context.Background(), os.Args)
+	if err := store.CloseInstance(); err != nil {
+		log.Println(err)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer store.Close()
+}
 
-	cmd := &cli.Command{
-		Name:     "kaido",
-		Usage:    "Collect kaido battle tour time records",
-		Commands: commands.Commands(),
+// repairs reports whether the command given by args still runs when the config file cannot be read,
+// help and the commands finding and fixing the mistake have to work.
+func repairs(args []string) bool {
+	if len(args) == 0 || slices.Contains(args, "-h") || slices.Contains(args, "--help") {
+		return true
 	}
-
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
-		log.Fatal(err)
+	switch args[0] {
+	case "help", "h":
+		return true
+	case "config":
+		return len(args) == 1 || slices.Contains([]string{"validate", "edit", "help", "h"}, args[1])
 	}
+	return false
 }
@@ -30,3 +30,14 @@ type Record struct {
 	CarName string `json:"carName"`
 	Time    string `json:"time"`
 }
+
+// StageID fully qualifies a stage, stage names alone are not unique across tracks.
+type StageID struct {
+	Region string `json:"region"`
+	Track  string `json:"track"`
+	Stage  string `json:"stage"`
+}
+
+func (id StageID) String() string {
+	return id.Region + "/" + id.Track + "/" + id.Stage
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/discord"
+)
+
+const (
+	ALL_TIME_STR      = "New all-time fastest lap! %s in %s by %s"
+	CURR_MONTH_STR    = "New fastest lap this month! %s in %s by %s"
+	NEW_ENTRANT_STR   = "%s entered the leaderboard at #%d with %s in %s"
+	PERSONAL_BEST_STR = "%s improved to %s (#%d) in %s"
+	RANK_CHANGED_STR  = "%s moved from #%d to #%d in %s"
+	DROPPED_OFF_STR   = "%s dropped off the leaderboard in %s"
+	TIME_REMOVED_STR  = "%s's time of %s was removed in %s"
+
+	ALL_TIME_COLOR   = 0xf1c40f
+	CURR_MONTH_COLOR = 0x3498db
+	GAIN_COLOR       = 0x2ecc71
+	LOSS_COLOR       = 0xe74c3c
+)
+
+type Discord struct {
+	WebhookURL string
+	BatchSize  int
+}
+
+func newDiscord(cfg config.Notifier) (Notifier, error) {
+	if len(cfg.WebhookURL) == 0 {
+		return nil, errors.New("webhook url cannot be empty")
+	}
+	return &Discord{
+		WebhookURL: cfg.WebhookURL,
+		// discord does not accept more than 10 embeds per message
+		BatchSize: 10,
+	}, nil
+}
+
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+func (d *Discord) Notify(ctx context.Context, events []Event) error {
+	embeds := make([]discord.Embed, 0, len(events))
+	for _, e := range events {
+		embeds = append(embeds, embed(e))
+	}
+
+	var errs []error
+	var delivered []int
+	for i := 0; i < len(embeds); i += d.BatchSize {
+		end := min(i+d.BatchSize, len(embeds))
+		if err := discord.SendEmbeds(ctx, embeds[i:end], d.WebhookURL); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for j := i; j < end; j++ {
+			delivered = append(delivered, j)
+		}
+	}
+	if len(errs) > 0 && len(delivered) > 0 {
+		return &PartialError{Delivered: delivered, Err: errors.Join(errs...)}
+	}
+	return errors.Join(errs...)
+}
+
+func text(e Event) string {
+	r := e.Record
+	switch e.Kind {
+	case KindNewEntrant:
+		return fmt.Sprintf(NEW_ENTRANT_STR, r.Player, r.Rank, r.Time, e.StageID)
+	case KindPersonalBest:
+		return fmt.Sprintf(PERSONAL_BEST_STR, r.Player, r.Time, r.Rank, e.StageID)
+	case KindRankGained, KindRankLost:
+		return fmt.Sprintf(RANK_CHANGED_STR, r.Player, e.Previous.Rank, r.Rank, e.StageID)
+	case KindDroppedOff:
+		return fmt.Sprintf(DROPPED_OFF_STR, r.Player, e.StageID)
+	case KindTimeRemoved:
+		if e.Previous != nil {
+			return fmt.Sprintf(TIME_REMOVED_STR, r.Player, e.Previous.Time, e.StageID)
+		}
+		return fmt.Sprintf(TIME_REMOVED_STR, r.Player, r.Time, e.StageID)
+	}
+
+	msg := ALL_TIME_STR
+	if e.CurrentMonth {
+		msg = CURR_MONTH_STR
+	}
+	return fmt.Sprintf(msg, r.Time, e.StageID, r.Player)
+}
+
+func title(e Event) (string, int) {
+	var title string
+	color := GAIN_COLOR
+
+	switch e.Kind {
+	case KindNewRecord:
+		if e.CurrentMonth {
+			return "New monthly record", CURR_MONTH_COLOR
+		}
+		return "New all-time record", ALL_TIME_COLOR
+	case KindNewEntrant:
+		title = "New leaderboard entry"
+	case KindPersonalBest:
+		title = "Personal best"
+	case KindRankGained:
+		title = "Rank gained"
+	case KindRankLost:
+		title, color = "Rank lost", LOSS_COLOR
+	case KindDroppedOff:
+		title, color = "Dropped off the leaderboard", LOSS_COLOR
+	case KindTimeRemoved:
+		title, color = "Time removed", LOSS_COLOR
+	default:
+		title = string(e.Kind)
+	}
+
+	if e.CurrentMonth {
+		title += " this month"
+	}
+	return title, color
+}
+
+func embed(e Event) discord.Embed {
+	title, color := title(e)
+
+	em := discord.Embed{
+		Title:       title,
+		Description: text(e),
+		Url:         e.Url,
+		Color:       color,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+	}
+
+	field := func(name, value string) {
+		// discord rejects fields with an empty value
+		if len(value) == 0 {
+			return
+		}
+		em.Fields = append(em.Fields, discord.EmbedField{Name: name, Value: value, Inline: true})
+	}
+
+	field("Region", e.Region)
+	field("Track", e.Track)
+	field("Stage", e.Stage)
+	field("Car", e.Record.CarName)
+	field("Lap time", e.Record.Time)
+	if e.Record.Rank > 0 {
+		field("Rank", fmt.Sprintf("#%d", e.Record.Rank))
+	}
+	if e.Previous != nil {
+		if e.Kind == KindNewRecord {
+			field("Previous holder", e.Previous.Player)
+		} else {
+			field("Previous rank", fmt.Sprintf("#%d", e.Previous.Rank))
+		}
+		field("Previous time", e.Previous.Time)
+	}
+	if e.Improvement > 0 {
+		field("Improvement", fmt.Sprintf("-%.3fs", e.Improvement.Seconds()))
+	}
+
+	return em
+}
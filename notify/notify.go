@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+)
+
+type Kind string
+
+const (
+	KindNewRecord    Kind = "new_record"
+	KindNewEntrant   Kind = "new_entrant"
+	KindPersonalBest Kind = "personal_best"
+	KindRankGained   Kind = "rank_gained"
+	KindRankLost     Kind = "rank_lost"
+	KindDroppedOff   Kind = "dropped_off"
+	KindTimeRemoved  Kind = "time_removed"
+)
+
+// Event describes a single change on a stage leaderboard.
+type Event struct {
+	Kind         Kind `json:"kind"`
+	CurrentMonth bool `json:"current_month"`
+	models.StageID
+	Url      string         `json:"url"`
+	Record   models.Record  `json:"record"`
+	Previous *models.Record `json:"previous,omitempty"`
+	// Improvement is how much faster the record is compared to the previous one
+	Improvement time.Duration `json:"improvement"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, events []Event) error
+}
+
+// PartialError is returned by a notifier that delivered only some of the events, Delivered holds
+// their indexes so they are not sent again.
+type PartialError struct {
+	Delivered []int
+	Err       error
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+type Factory func(cfg config.Notifier) (Notifier, error)
+
+var (
+	factories = map[string]Factory{
+		"discord": newDiscord,
+	}
+)
+
+// Register makes a notifier type available to the config under the given name.
+func Register(kind string, f Factory) {
+	factories[kind] = f
+}
+
+// Registry sends events to the routes they match, and the rest to every notifier.
+type Registry struct {
+	notifiers []*webhook
+	routes    []route
+}
+
+func FromConfig(cfg *config.Config) (*Registry, error) {
+	r := &Registry{}
+	webhooks := make(sinks)
+
+	// a url configured more than once is a single sink, so it still gets every event once
+	add := func(name string, nc config.Notifier) error {
+		w, err := webhooks.get(name, nc)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(r.notifiers, w) {
+			r.notifiers = append(r.notifiers, w)
+		}
+		return nil
+	}
+
+	// the webhook set from the prompt is always a discord sink
+	if len(cfg.DiscordWebhookURL) > 0 {
+		if err := add("discord_webhook_url", config.Notifier{Type: "discord", WebhookURL: cfg.DiscordWebhookURL}); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, nc := range cfg.Notifiers {
+		if err := add(fmt.Sprintf("notifiers[%d]", i), nc); err != nil {
+			return nil, err
+		}
+	}
+
+	routes, err := buildRoutes(cfg.Routes, webhooks)
+	if err != nil {
+		return nil, err
+	}
+	r.routes = routes
+
+	return r, nil
+}
+
+func (r *Registry) Name() string {
+	return "registry"
+}
+
+func (r *Registry) Len() int {
+	return len(r.notifiers) + len(r.routes)
+}
+
+// Notify sends the events to the webhooks of the routes they match and the unmatched ones to every
+// notifier, one failing sink does not stop the others.
+func (r *Registry) Notify(ctx context.Context, events []Event) error {
+	var errs []error
+	for w, indexes := range r.targets(events) {
+		if err := w.Notify(ctx, pick(events, indexes)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", w.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// targets groups the indexes of the events by the sink they go to, the events no route matches go
+// to every notifier.
+func (r *Registry) targets(events []Event) map[*webhook][]int {
+	targets, unrouted := dispatch(r.routes, events)
+	if len(unrouted) > 0 {
+		for _, n := range r.notifiers {
+			targets[n] = append(targets[n], unrouted...)
+		}
+	}
+	return targets
+}
+
+func pick(events []Event, indexes []int) []Event {
+	picked := make([]Event, 0, len(indexes))
+	for _, i := range indexes {
+		picked = append(picked, events[i])
+	}
+	return picked
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dimfu/kaido/store"
+)
+
+// Outbox persists events in the store so they survive until a notifier accepted them.
+type Outbox struct {
+	Store store.Store
+}
+
+// Key identifies the same announcement across runs. The previous place is part of it, losing the
+// same rank again later on is a new announcement.
+func (e Event) Key() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%t|%s|%s|%s|%s|%s|%d", e.Kind, e.CurrentMonth, e.Region, e.Track, e.Stage, e.Record.Player, e.Record.Time, e.Record.Rank)
+	if e.Previous != nil {
+		fmt.Fprintf(h, "|%s|%d", e.Previous.Time, e.Previous.Rank)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (o *Outbox) Enqueue(events ...Event) error {
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := store.Enqueue(o.Store, e.Key(), payload); err != nil {
+			return fmt.Errorf("cannot enqueue notification: %v", err)
+		}
+	}
+	return nil
+}
+
+// Drain sends every pending event to the sinks it goes to. Delivery is tracked per sink, so when one
+// of them fails only that sink gets the events again on the next drain. The number of events that
+// reached all their sinks is returned.
+func (o *Outbox) Drain(ctx context.Context, r *Registry) (int, error) {
+	entries, err := store.Pending(o.Store)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		var e Event
+		if err := json.Unmarshal(entry.Payload, &e); err != nil {
+			return 0, fmt.Errorf("malformed outbox entry %s: %v", entry.Key, err)
+		}
+		events = append(events, e)
+	}
+
+	var errs []error
+	failed := make([]bool, len(entries))
+	for w, indexes := range r.targets(events) {
+		var pending []int
+		var keys []string
+		for _, i := range indexes {
+			if !slices.Contains(entries[i].Sent, w.id) {
+				pending = append(pending, i)
+				keys = append(keys, entries[i].Key)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		sent := keys
+		if err := w.Notify(ctx, pick(events, pending)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", w.name, err))
+			// the events the sink did accept are not sent to it again
+			sent = nil
+			var partial *PartialError
+			errors.As(err, &partial)
+			for j, i := range pending {
+				if partial != nil && slices.Contains(partial.Delivered, j) {
+					sent = append(sent, keys[j])
+					continue
+				}
+				failed[i] = true
+			}
+		}
+		if len(sent) == 0 {
+			continue
+		}
+		if err := store.MarkSent(o.Store, w.id, sent...); err != nil {
+			return 0, err
+		}
+	}
+
+	var delivered []string
+	for i, entry := range entries {
+		if !failed[i] {
+			delivered = append(delivered, entry.Key)
+		}
+	}
+	if err := store.MarkDelivered(o.Store, delivered...); err != nil {
+		return 0, err
+	}
+	return len(delivered), errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimfu/kaido/models"
+	"github.com/dimfu/kaido/store"
+)
+
+type flaky struct {
+	recorder
+	fail bool
+}
+
+func (f *flaky) Notify(ctx context.Context, events []Event) error {
+	if f.fail {
+		return errors.New("webhook is down")
+	}
+	return f.recorder.Notify(ctx, events)
+}
+
+func TestDrainRetriesFailedSinksOnly(t *testing.T) {
+	s := store.NewMemoryStore()
+	outbox := &Outbox{Store: s}
+	up, down := &flaky{}, &flaky{fail: true}
+	r := &Registry{notifiers: []*webhook{
+		{name: "notifiers[0]", id: "up", Notifier: up},
+		{name: "notifiers[1]", id: "down", Notifier: down},
+	}}
+
+	events := []Event{
+		{Kind: KindNewRecord, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Player: "takumi", Rank: 1}},
+		{Kind: KindNewEntrant, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Player: "iketani", Rank: 9}},
+	}
+	if err := outbox.Enqueue(events...); err != nil {
+		t.Fatalf("error while enqueueing events: %v\n", err)
+	}
+
+	if sent, err := outbox.Drain(context.Background(), r); err == nil || sent != 0 {
+		t.Fatalf("expected the failing sink to keep the events pending, sent %d: %v\n", sent, err)
+	}
+	if len(up.events) != 2 || len(down.events) != 0 {
+		t.Fatalf("expected the working sink to get both events, got %d and %d\n", len(up.events), len(down.events))
+	}
+
+	down.fail = false
+	if sent, err := outbox.Drain(context.Background(), r); err != nil || sent != 2 {
+		t.Fatalf("expected both events to be delivered, sent %d: %v\n", sent, err)
+	}
+	if len(up.events) != 2 {
+		t.Fatalf("working sink got the events again, %d in total\n", len(up.events))
+	}
+	if len(down.events) != 2 {
+		t.Fatalf("expected the recovered sink to get both events, got %d\n", len(down.events))
+	}
+
+	if pending, err := store.Pending(s); err != nil || len(pending) != 0 {
+		t.Fatalf("expected nothing pending, got %d: %v\n", len(pending), err)
+	}
+}
+
+func TestDrainKeepsDeliveredBatches(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// the second batch of the first drain is rejected
+		if requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := store.NewMemoryStore()
+	outbox := &Outbox{Store: s}
+	r := &Registry{notifiers: []*webhook{{name: "discord_webhook_url", id: "discord", Notifier: &Discord{WebhookURL: srv.URL, BatchSize: 1}}}}
+
+	events := []Event{
+		{Kind: KindNewRecord, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Player: "takumi", Rank: 1}},
+		{Kind: KindNewEntrant, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Player: "iketani", Rank: 9}},
+	}
+	if err := outbox.Enqueue(events...); err != nil {
+		t.Fatalf("error while enqueueing events: %v\n", err)
+	}
+
+	if sent, err := outbox.Drain(context.Background(), r); err == nil || sent != 1 {
+		t.Fatalf("expected the first batch to be delivered, sent %d: %v\n", sent, err)
+	}
+	if sent, err := outbox.Drain(context.Background(), r); err != nil || sent != 1 {
+		t.Fatalf("expected the failed batch to be delivered, sent %d: %v\n", sent, err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected only the failed batch to be sent again, got %d requests\n", requests)
+	}
+}
+
+func TestEventKey(t *testing.T) {
+	lost := Event{Kind: KindRankLost, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Player: "iketani", Time: "2:31.000", Rank: 4}, Previous: &models.Record{Player: "iketani", Time: "2:31.000", Rank: 3}}
+	again := lost
+	again.Previous = &models.Record{Player: "iketani", Time: "2:31.000", Rank: 2}
+	if lost.Key() == again.Key() {
+		t.Fatal("losing a place again from another rank should be a new announcement")
+	}
+
+	same := lost
+	same.Previous = &models.Record{Player: "iketani", Time: "2:31.000", Rank: 3}
+	if lost.Key() != same.Key() {
+		t.Fatal("the same announcement should keep its key")
+	}
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+)
+
+const (
+	PODIUM_SIZE = 3
+)
+
+// route is a config.Route with a notifier for each of its webhooks.
+type route struct {
+	config.Route
+	notifiers []*webhook
+}
+
+// webhook is a destination of the registry, every setting using the same url shares it so an event is sent once.
+type webhook struct {
+	// name is the setting the webhook was first configured at eg; routes[0].webhooks[1]
+	name string
+	// id identifies the destination in the outbox across runs, it follows the url rather than the setting
+	id string
+	Notifier
+}
+
+// sinks are the webhooks of a registry by their id.
+type sinks map[string]*webhook
+
+// get returns the webhook of the destination, it is only created the first time its url is configured.
+func (s sinks) get(name string, nc config.Notifier) (*webhook, error) {
+	id := sinkID(nc.Type, nc.WebhookURL)
+	if w, exists := s[id]; exists {
+		return w, nil
+	}
+	factory, exists := factories[nc.Type]
+	if !exists {
+		return nil, fmt.Errorf("unknown notifier type: %s", nc.Type)
+	}
+	n, err := factory(nc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s notifier: %v", nc.Type, err)
+	}
+	w := &webhook{name: name, id: id, Notifier: n}
+	s[id] = w
+	return w, nil
+}
+
+// sinkID derives the outbox id of a destination, the url is hashed so no webhook token ends up in the store.
+func sinkID(kind, url string) string {
+	h := sha1.Sum([]byte(kind + "|" + url))
+	return kind + ":" + hex.EncodeToString(h[:8])
+}
+
+// Is reports whether the event is of one of the config.RouteEvents types.
+func (e Event) Is(kind string) bool {
+	switch kind {
+	case config.ROUTE_ALL_TIME_RECORD:
+		return e.Kind == KindNewRecord && !e.CurrentMonth
+	case config.ROUTE_MONTHLY_RECORD:
+		return e.Kind == KindNewRecord && e.CurrentMonth
+	case config.ROUTE_PODIUM:
+		return podiumChanged(e)
+	}
+	return false
+}
+
+// podiumChanged reports whether the event moves someone onto, off or within the podium, a better time
+// that keeps the same place is not a change.
+func podiumChanged(e Event) bool {
+	// the previous record of a new record can be someone else's, then the player landed on the podium
+	if e.Previous == nil || e.Previous.Player != e.Record.Player {
+		return onPodium(&e.Record)
+	}
+	return e.Record.Rank != e.Previous.Rank && (onPodium(&e.Record) || onPodium(e.Previous))
+}
+
+func onPodium(r *models.Record) bool {
+	return r != nil && r.Rank > 0 && r.Rank <= PODIUM_SIZE
+}
+
+func (r *route) matches(e Event) bool {
+	if !matchName(r.Region, e.Region) || !matchName(r.Track, e.Track) || !matchName(r.Stage, e.Stage) {
+		return false
+	}
+	return len(r.Events) == 0 || slices.ContainsFunc(r.Events, e.Is)
+}
+
+// matchName compares leaderboard names regardless of case, an empty want matches anything.
+func matchName(want, got string) bool {
+	return len(want) == 0 || strings.EqualFold(want, got)
+}
+
+// buildRoutes creates the notifiers of the routes, a webhook already in webhooks is reused so every url is
+// created once across the registry.
+func buildRoutes(routes []config.Route, webhooks sinks) ([]route, error) {
+	result := make([]route, 0, len(routes))
+	for i, rc := range routes {
+		r := route{Route: rc}
+		for j, url := range rc.Webhooks {
+			w, err := webhooks.get(fmt.Sprintf("routes[%d].webhooks[%d]", i, j), config.Notifier{Type: "discord", WebhookURL: url})
+			if err != nil {
+				return nil, fmt.Errorf("routes[%d].webhooks[%d]: %v", i, j, err)
+			}
+			if !slices.Contains(r.notifiers, w) {
+				r.notifiers = append(r.notifiers, w)
+			}
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// dispatch groups the indexes of the events by the route webhooks they go to, the events no route
+// matches are returned apart.
+func dispatch(routes []route, events []Event) (map[*webhook][]int, []int) {
+	routed := make(map[*webhook][]int)
+	var unrouted []int
+	for i, e := range events {
+		var targets []*webhook
+		for j := range routes {
+			if !routes[j].matches(e) {
+				continue
+			}
+			for _, w := range routes[j].notifiers {
+				if !slices.Contains(targets, w) {
+					targets = append(targets, w)
+				}
+			}
+		}
+		if len(targets) == 0 {
+			unrouted = append(unrouted, i)
+			continue
+		}
+		for _, w := range targets {
+			routed[w] = append(routed[w], i)
+		}
+	}
+	return routed, unrouted
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/dimfu/kaido/config"
+	"github.com/dimfu/kaido/models"
+)
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Notify(ctx context.Context, events []Event) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+func TestRoutes(t *testing.T) {
+	routes, err := buildRoutes([]config.Route{
+		{Region: "gunma", Webhooks: []string{"https://discord.com/api/webhooks/1/gunma"}},
+		{Events: []string{config.ROUTE_ALL_TIME_RECORD}, Webhooks: []string{"https://discord.com/api/webhooks/2/hall-of-fame"}},
+		{Region: "Gunma", Track: "akina", Events: []string{config.ROUTE_PODIUM}, Webhooks: []string{"https://discord.com/api/webhooks/1/gunma"}},
+	}, make(sinks))
+	if err != nil {
+		t.Fatalf("error while building routes: %v\n", err)
+	}
+
+	// the third route shares the webhook of the first one
+	gunma, hallOfFame, fallback := &recorder{}, &recorder{}, &recorder{}
+	routes[0].notifiers[0].Notifier = gunma
+	routes[1].notifiers[0].Notifier = hallOfFame
+	if routes[2].notifiers[0] != routes[0].notifiers[0] {
+		t.Fatal("routes with the same webhook should share its notifier")
+	}
+
+	gunmaRecord := Event{Kind: KindNewRecord, StageID: models.StageID{Region: "gunma", Track: "akina", Stage: "downhill"}, Record: models.Record{Rank: 1}}
+	gunmaMonthly := gunmaRecord
+	gunmaMonthly.CurrentMonth = true
+	akinaRecord := Event{Kind: KindNewRecord, StageID: models.StageID{Region: "akina", Track: "usui", Stage: "uphill"}, Record: models.Record{Rank: 1}}
+	monthlyEntrant := Event{Kind: KindNewEntrant, CurrentMonth: true, StageID: models.StageID{Region: "akina", Track: "usui", Stage: "uphill"}, Record: models.Record{Rank: 7}}
+
+	r := &Registry{notifiers: []*webhook{{name: "notifiers[0]", id: "fallback", Notifier: fallback}}, routes: routes}
+	if err := r.Notify(context.Background(), []Event{gunmaRecord, gunmaMonthly, akinaRecord, monthlyEntrant}); err != nil {
+		t.Fatalf("error while notifying: %v\n", err)
+	}
+
+	if !slices.Equal(gunma.events, []Event{gunmaRecord, gunmaMonthly}) {
+		t.Fatalf("gunma channel should get every gunma event once, got %v\n", gunma.events)
+	}
+	if !slices.Equal(hallOfFame.events, []Event{gunmaRecord, akinaRecord}) {
+		t.Fatalf("hall of fame should get all-time records only, got %v\n", hallOfFame.events)
+	}
+	if !slices.Equal(fallback.events, []Event{monthlyEntrant}) {
+		t.Fatalf("events without a route should go to the notifiers, got %v\n", fallback.events)
+	}
+}
+
+func TestSharedWebhooks(t *testing.T) {
+	main := "https://discord.com/api/webhooks/1/main"
+	cfg := &config.Config{Profile: &config.Profile{
+		DiscordWebhookURL: main,
+		Notifiers:         []config.Notifier{{Type: "discord", WebhookURL: main}},
+		Routes:            []config.Route{{Region: "gunma", Webhooks: []string{main}}},
+	}}
+	r, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatalf("error while building the registry: %v\n", err)
+	}
+	if len(r.notifiers) != 1 || r.routes[0].notifiers[0] != r.notifiers[0] {
+		t.Fatalf("every use of a webhook url should share one notifier, got %d notifiers\n", len(r.notifiers))
+	}
+
+	recorder := &recorder{}
+	r.notifiers[0].Notifier = recorder
+	gunma := Event{Kind: KindNewRecord, StageID: models.StageID{Region: "gunma"}}
+	akina := Event{Kind: KindNewRecord, StageID: models.StageID{Region: "akina"}}
+	if err := r.Notify(context.Background(), []Event{gunma, akina}); err != nil {
+		t.Fatalf("error while notifying: %v\n", err)
+	}
+	if !slices.Equal(recorder.events, []Event{gunma, akina}) {
+		t.Fatalf("the webhook should get every event once, got %v\n", recorder.events)
+	}
+}
+
+func TestEventIs(t *testing.T) {
+	tests := []struct {
+		event Event
+		kinds []string
+	}{
+		{Event{Kind: KindNewRecord, Record: models.Record{Rank: 1}}, []string{config.ROUTE_ALL_TIME_RECORD, config.ROUTE_PODIUM}},
+		{Event{Kind: KindNewRecord, CurrentMonth: true, Record: models.Record{Rank: 1}}, []string{config.ROUTE_MONTHLY_RECORD, config.ROUTE_PODIUM}},
+		{Event{Kind: KindRankLost, Record: models.Record{Rank: 4}, Previous: &models.Record{Rank: 3}}, []string{config.ROUTE_PODIUM}},
+		{Event{Kind: KindNewEntrant, Record: models.Record{Rank: 4}}, nil},
+		{Event{Kind: KindNewEntrant, Record: models.Record{Rank: 2}}, []string{config.ROUTE_PODIUM}},
+		{Event{Kind: KindNewRecord, Record: models.Record{Player: "a", Rank: 1}, Previous: &models.Record{Player: "a", Rank: 1}}, []string{config.ROUTE_ALL_TIME_RECORD}},
+		{Event{Kind: KindPersonalBest, Record: models.Record{Player: "a", Rank: 2}, Previous: &models.Record{Player: "a", Rank: 2}}, nil},
+		{Event{Kind: KindRankGained, Record: models.Record{Player: "a", Rank: 5}, Previous: &models.Record{Player: "a", Rank: 7}}, nil},
+	}
+
+	for _, tt := range tests {
+		var kinds []string
+		for _, kind := range config.RouteEvents {
+			if tt.event.Is(kind) {
+				kinds = append(kinds, kind)
+			}
+		}
+		if !slices.Equal(kinds, tt.kinds) {
+			t.Fatalf("expected %s to be %v, got %v\n", tt.event.Kind, tt.kinds, kinds)
+		}
+	}
+}
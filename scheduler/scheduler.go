@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs every job on its own interval until the context is done.
+// Runs never overlap, so jobs can share the store and config without extra locking.
+type Scheduler struct {
+	jobs []Job
+	mu   sync.Mutex
+}
+
+func New(jobs ...Job) *Scheduler {
+	return &Scheduler{
+		jobs: jobs,
+	}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	log.Printf("scheduled %s every %s\n", job.Name, job.Interval)
+
+	// run once right away instead of waiting for the first tick
+	s.run(ctx, job)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the context might be cancelled while waiting for another job to finish
+	if ctx.Err() != nil {
+		return
+	}
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Printf("poll %s failed after %s: %v\n", job.Name, time.Since(start), err)
+		return
+	}
+	log.Printf("poll %s finished (took %s)\n", job.Name, time.Since(start))
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunsNeverOverlap(t *testing.T) {
+	var running, overlaps, runs atomic.Int32
+	job := func(ctx context.Context) error {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer running.Add(-1)
+		runs.Add(1)
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	New(
+		Job{Name: "all-time", Interval: time.Millisecond, Run: job},
+		Job{Name: "current month", Interval: time.Millisecond, Run: job},
+	).Run(ctx)
+
+	if overlaps.Load() > 0 {
+		t.Fatalf("%d runs overlapped another one\n", overlaps.Load())
+	}
+	if runs.Load() < 2 {
+		t.Fatalf("expected every job to run, got %d runs\n", runs.Load())
+	}
+	if running.Load() != 0 {
+		t.Fatal("Run returned while a job was still running")
+	}
+}
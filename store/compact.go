@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the manifest names the output of a merge, or - when nothing was retained, followed by its input ids
+func manifestPath(base string) string {
+	return base + ".compact"
+}
+
+// writeManifest atomically records a merge before any of its inputs is touched.
+func writeManifest(base string, out *written, ids []int) error {
+	fields := []string{"-"}
+	if out != nil {
+		fields[0] = filepath.Base(out.path)
+	}
+	for _, id := range ids {
+		fields = append(fields, strconv.Itoa(id))
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(base), "temp-*.compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if _, err := temp.WriteString(strings.Join(fields, " ") + "\n"); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), manifestPath(base)); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(base))
+}
+
+// recoverMerge finishes or rolls back a merge that was interrupted. As long as its output was not put in
+// place of the newest input the segments are untouched and the output is dropped. After that the inputs
+// left behind only hold records the output already has, so they are removed.
+func recoverMerge(base string) error {
+	data, err := os.ReadFile(manifestPath(base))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid compaction manifest %s", manifestPath(base))
+	}
+	ids := make([]int, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid compaction manifest %s", manifestPath(base))
+		}
+		ids = append(ids, id)
+	}
+
+	inputs := ids
+	if fields[0] != "-" {
+		output := filepath.Join(filepath.Dir(base), fields[0])
+		if _, err := os.Stat(output); err == nil {
+			if err := os.Remove(output); err != nil {
+				return err
+			}
+			return os.Remove(manifestPath(base))
+		}
+		// the output took the place of the newest input
+		inputs = ids[:len(ids)-1]
+	}
+
+	for _, id := range inputs {
+		os.Remove(hintPath(segmentPath(base, id)))
+		if err := os.Remove(segmentPath(base, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := syncDir(filepath.Dir(base)); err != nil {
+		return err
+	}
+	return os.Remove(manifestPath(base))
+}
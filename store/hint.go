@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	HINT_MAGIC       = "KHNT"
+	HINT_HEADER_SIZE = 12 // Magic = 4 bytes, Data size = 8 bytes
+	HINT_ENTRY_SIZE  = 16 // Key = 4 bytes, Offset = 8 bytes, Size = 4 bytes
+)
+
+var (
+	ERR_INVALID_HINT = errors.New("hint file is invalid")
+)
+
+// hint locates a record in a segment file without having to read its value.
+type hint struct {
+	key    string
+	offset int64
+	size   uint32
+}
+
+func hintPath(storePath string) string {
+	return storePath + ".hint"
+}
+
+// writeHints atomically replaces the hint file, dataSize is the size of the segment file the hints describe.
+func writeHints(path string, dataSize int64, hints []hint) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "temp-*.hint")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	checksum := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(temp, checksum))
+
+	header := make([]byte, HINT_HEADER_SIZE)
+	copy(header[0:4], HINT_MAGIC)
+	binary.LittleEndian.PutUint64(header[4:12], uint64(dataSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	entry := make([]byte, HINT_ENTRY_SIZE)
+	for _, h := range hints {
+		binary.LittleEndian.PutUint32(entry[0:4], uint32(len(h.key)))
+		binary.LittleEndian.PutUint64(entry[4:12], uint64(h.offset))
+		binary.LittleEndian.PutUint32(entry[12:16], h.size)
+		if _, err := w.Write(entry); err != nil {
+			return err
+		}
+		if _, err := w.WriteString(h.key); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// the checksum trails the file and covers everything before it
+	if err := binary.Write(temp, binary.LittleEndian, checksum.Sum32()); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// readHints returns the hints and the size of the segment file they were written for.
+func readHints(path string) (int64, []hint, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(buf) < HINT_HEADER_SIZE+4 || !bytes.Equal(buf[0:4], []byte(HINT_MAGIC)) {
+		return 0, nil, ERR_INVALID_HINT
+	}
+
+	body, sum := buf[:len(buf)-4], binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return 0, nil, ERR_INVALID_HINT
+	}
+
+	dataSize := int64(binary.LittleEndian.Uint64(body[4:12]))
+
+	var hints []hint
+	for pos := HINT_HEADER_SIZE; pos < len(body); {
+		if pos+HINT_ENTRY_SIZE > len(body) {
+			return 0, nil, ERR_INVALID_HINT
+		}
+		keyLen := int(binary.LittleEndian.Uint32(body[pos : pos+4]))
+		offset := int64(binary.LittleEndian.Uint64(body[pos+4 : pos+12]))
+		size := binary.LittleEndian.Uint32(body[pos+12 : pos+16])
+		pos += HINT_ENTRY_SIZE
+
+		if pos+keyLen > len(body) {
+			return 0, nil, ERR_INVALID_HINT
+		}
+		hints = append(hints, hint{key: string(body[pos : pos+keyLen]), offset: offset, size: size})
+		pos += keyLen
+	}
+
+	return dataSize, hints, nil
+}
+
+// loadHints indexes the segment from its hint file and returns the offset from which the
+// segment still has to be scanned, 0 when there is no usable hint file.
+func (s *LogStore) loadHints(seg *segment) int64 {
+	dataSize, hints, err := readHints(hintPath(seg.file.Name()))
+	if err != nil || dataSize > seg.size {
+		return 0
+	}
+
+	// make sure the hints belong to this segment by reading back the last record they point at
+	if len(hints) > 0 {
+		last := hints[len(hints)-1]
+		r, length, err := readRecord(seg.file, last.offset, seg.size, formatV1)
+		if err != nil || string(r.Key) != last.key || length != int64(last.size) || last.offset+length != dataSize {
+			return 0
+		}
+	}
+
+	for _, h := range hints {
+		s.index(h.key, location{segment: seg.id, offset: h.offset, size: h.size})
+	}
+	return dataSize
+}
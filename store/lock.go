@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LOCK_FILE          = "kaido.lock"
+	LOCK_POLL_INTERVAL = 500 * time.Millisecond
+)
+
+var (
+	errLocked = errors.New("workspace is locked")
+)
+
+// LockedError is returned when another process holds the workspace lock.
+type LockedError struct {
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("another kaido is running (pid %d)", e.PID)
+}
+
+// lock keeps other kaido processes out of the workspace until it is released.
+type lock struct {
+	file *os.File
+}
+
+// lockWorkspace takes the lock of the workspace at dir, with wait it blocks until the lock is free
+// or ctx is done instead of failing right away.
+func lockWorkspace(ctx context.Context, dir string, wait bool) (*lock, error) {
+	path := filepath.Join(dir, LOCK_FILE)
+	waiting := false
+	for {
+		l, err := tryLock(path)
+		if err == nil {
+			// the pid is only informative, it tells the next process who is holding the lock
+			l.file.Truncate(0)
+			l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+			return l, nil
+		}
+		if err != errLocked {
+			return nil, fmt.Errorf("cannot lock workspace: %v", err)
+		}
+
+		locked := &LockedError{PID: readPID(path)}
+		if !wait {
+			return nil, locked
+		}
+		if !waiting {
+			log.Printf("%v, waiting for it to finish\n", locked)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(LOCK_POLL_INTERVAL):
+		}
+	}
+}
+
+func readPID(path string) int {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf)))
+	return pid
+}
@@ -0,0 +1,39 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package store
+
+import (
+	"os"
+)
+
+// tryLock creates the lock file exclusively, a lock file left behind by a process that is
+// gone is taken over.
+func tryLock(path string) (*lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		// no pid yet means the other process is still writing it
+		pid := readPID(path)
+		if pid <= 0 {
+			return nil, errLocked
+		}
+		if alive(pid) {
+			return nil, errLocked
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errLocked
+		}
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			return nil, errLocked
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock{file: file}, nil
+}
+
+func (l *lock) release() error {
+	l.file.Close()
+	return os.Remove(l.file.Name())
+}
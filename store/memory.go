@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps every record in memory, nothing survives Close. It is meant for tests
+// and dry runs that should not touch the workspace.
+type MemoryStore struct {
+	// history holds every version of each key oldest first, the last one is the current value
+	history map[string][]*Record
+	mu      sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		history: make(map[string][]*Record),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Record, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key cannot be empty")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.history[key]
+	if len(versions) == 0 {
+		return nil, ERR_KEY_NOT_FOUND
+	}
+	return clone(versions[len(versions)-1]), nil
+}
+
+func (s *MemoryStore) Put(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(r.Key)
+	s.history[key] = append(s.history[key], clone(&r))
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.history[key]; !exists {
+		return ERR_KEY_NOT_FOUND
+	}
+	delete(s.history, key)
+	return nil
+}
+
+func (s *MemoryStore) DeletePrefix(prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.keys(prefix)
+	for _, key := range keys {
+		delete(s.history, key)
+	}
+	return len(keys), nil
+}
+
+func (s *MemoryStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys("")
+}
+
+func (s *MemoryStore) Scan(prefix string) iter.Seq2[*Record, error] {
+	s.mu.RLock()
+	keys := s.keys(prefix)
+	s.mu.RUnlock()
+
+	return func(yield func(*Record, error) bool) {
+		for _, key := range keys {
+			r, err := s.Get(key)
+			if err == ERR_KEY_NOT_FOUND {
+				continue
+			}
+			if !yield(r, err) {
+				return
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) History(key string) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.history[key]
+	if len(versions) == 0 {
+		return nil, ERR_KEY_NOT_FOUND
+	}
+
+	records := make([]*Record, 0, len(versions))
+	for _, r := range versions {
+		records = append(records, clone(r))
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) keys(prefix string) []string {
+	var keys []string
+	for key := range s.history {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// clone copies the record so callers cannot change what is stored through its slices.
+func clone(r *Record) *Record {
+	return &Record{
+		Timestamp: r.Timestamp,
+		Key:       slices.Clone(r.Key),
+		Value:     slices.Clone(r.Value),
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"iter"
+	"strings"
+)
+
+const (
+	// NAMESPACE_PREFIX starts the keys of a namespace, they are stored as @namespace/key
+	NAMESPACE_PREFIX = "@"
+)
+
+// Namespaced is a view of a store that only sees the keys of one namespace, the keys it hands
+// out do not carry the namespace. Closing it leaves the underlying store open.
+type Namespaced struct {
+	Store
+	prefix string
+}
+
+// Namespace returns the view of s for namespace, the empty namespace is s itself.
+func Namespace(s Store, namespace string) Store {
+	if len(namespace) == 0 {
+		return s
+	}
+	return &Namespaced{Store: s, prefix: NamespaceKey(namespace, "")}
+}
+
+// NamespaceKey is how key of namespace is stored.
+func NamespaceKey(namespace, key string) string {
+	if len(namespace) == 0 {
+		return key
+	}
+	return NAMESPACE_PREFIX + namespace + "/" + key
+}
+
+// SplitNamespace splits a stored key into its namespace and the key within it.
+func SplitNamespace(key string) (string, string) {
+	rest, found := strings.CutPrefix(key, NAMESPACE_PREFIX)
+	if !found {
+		return "", key
+	}
+	namespace, key, found := strings.Cut(rest, "/")
+	if !found {
+		return "", NAMESPACE_PREFIX + rest
+	}
+	return namespace, key
+}
+
+func (n *Namespaced) Get(key string) (*Record, error) {
+	r, err := n.Store.Get(n.prefix + key)
+	if err != nil {
+		return nil, err
+	}
+	return n.strip(r), nil
+}
+
+func (n *Namespaced) Put(r Record) error {
+	r.Key = append([]byte(n.prefix), r.Key...)
+	return n.Store.Put(r)
+}
+
+func (n *Namespaced) Delete(key string) error {
+	return n.Store.Delete(n.prefix + key)
+}
+
+func (n *Namespaced) DeletePrefix(prefix string) (int, error) {
+	return n.Store.DeletePrefix(n.prefix + prefix)
+}
+
+func (n *Namespaced) Keys() []string {
+	var keys []string
+	for _, key := range n.Store.Keys() {
+		if key, found := strings.CutPrefix(key, n.prefix); found {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (n *Namespaced) Scan(prefix string) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		for r, err := range n.Store.Scan(n.prefix + prefix) {
+			if err == nil {
+				r = n.strip(r)
+			}
+			if !yield(r, err) {
+				return
+			}
+		}
+	}
+}
+
+func (n *Namespaced) History(key string) ([]*Record, error) {
+	records, err := n.Store.History(n.prefix + key)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range records {
+		records[i] = n.strip(r)
+	}
+	return records, nil
+}
+
+func (n *Namespaced) Close() error {
+	return nil
+}
+
+func (n *Namespaced) strip(r *Record) *Record {
+	r.Key = []byte(strings.TrimPrefix(string(r.Key), n.prefix))
+	return r
+}
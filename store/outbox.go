@@ -0,0 +1,150 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	OUTBOX_KEY = "__outbox"
+	// delivered entries are kept around this long so the same record is not enqueued twice
+	OUTBOX_RETENTION = 7 * 24 * time.Hour
+)
+
+var (
+	// guards read-modify-write cycles on the outbox key
+	outboxMu sync.Mutex
+)
+
+type OutboxEntry struct {
+	Key         string          `json:"key"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+	// Sent lists the sinks that already accepted a pending entry
+	Sent []string `json:"sent,omitempty"`
+}
+
+// Enqueue adds a pending entry to the outbox, entries with a key that is already
+// pending or was recently delivered are ignored.
+func Enqueue(s Store, key string, payload []byte) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := outbox(s)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Key == key {
+			return nil
+		}
+	}
+
+	entries = append(entries, OutboxEntry{
+		Key:       key,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	})
+
+	return saveOutbox(s, entries)
+}
+
+// Pending returns every outbox entry that has not been delivered yet, oldest first.
+func Pending(s Store) ([]OutboxEntry, error) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := outbox(s)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []OutboxEntry{}
+	for _, e := range entries {
+		if e.DeliveredAt == nil {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+// MarkSent records that the sink accepted the entries, they are not sent to it again while the
+// other sinks are retried.
+func MarkSent(s Store, sink string, keys ...string) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := outbox(s)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		if e.DeliveredAt == nil && slices.Contains(keys, e.Key) && !slices.Contains(e.Sent, sink) {
+			entries[i].Sent = append(e.Sent, sink)
+		}
+	}
+	return saveOutbox(s, entries)
+}
+
+func MarkDelivered(s Store, keys ...string) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := outbox(s)
+	if err != nil {
+		return err
+	}
+
+	delivered := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		delivered[key] = true
+	}
+
+	now := time.Now()
+	kept := entries[:0]
+	for _, e := range entries {
+		if delivered[e.Key] && e.DeliveredAt == nil {
+			e.DeliveredAt = &now
+			e.Sent = nil
+		}
+		// drop delivered entries that are too old to matter for de-duplication
+		if e.DeliveredAt != nil && now.Sub(*e.DeliveredAt) > OUTBOX_RETENTION {
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	return saveOutbox(s, kept)
+}
+
+func outbox(s Store) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	r, err := s.Get(OUTBOX_KEY)
+	if err == ERR_KEY_NOT_FOUND {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(r.Value, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func saveOutbox(s Store, entries []OutboxEntry) error {
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.Put(Record{
+		Timestamp: time.Now().UnixNano(),
+		Key:       []byte(OUTBOX_KEY),
+		Value:     value,
+	})
+}
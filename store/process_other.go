@@ -0,0 +1,18 @@
+//go:build !unix
+
+package store
+
+import (
+	"os"
+)
+
+// alive reports whether the process is still running. Windows looks the process up in FindProcess,
+// where a process cannot be probed it is taken to be running.
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,22 @@
+//go:build unix && !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// alive reports whether the process is still running. FindProcess always succeeds on unix, the
+// process is probed with a signal that is never delivered instead.
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+	// a process of another user cannot be signalled but is still running
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
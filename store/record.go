@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+var (
+	ERR_UNSUPPORTED_VERSION = errors.New("store file was written by a newer kaido")
+	ERR_UNSUPPORTED_RECORD  = errors.New("record uses flags this kaido does not support")
+)
+
+// format is the layout of the records in a file.
+type format int
+
+const (
+	// formatLegacy records have no checksum and 32 bit timestamps in seconds
+	formatLegacy format = iota
+	// formatV0 records are checksummed but still have 32 bit timestamps in seconds and no file header
+	formatV0
+	// formatV1 files start with a header, records have nanosecond timestamps and flags
+	formatV1
+)
+
+func (f format) headerSize() int64 {
+	switch f {
+	case formatLegacy:
+		return LEGACY_HEADER_SIZE
+	case formatV0:
+		return V0_HEADER_SIZE
+	}
+	return HEADER_SIZE
+}
+
+func fileHeader() []byte {
+	buf := make([]byte, FILE_HEADER_SIZE)
+	copy(buf[0:4], FILE_MAGIC)
+	binary.LittleEndian.PutUint32(buf[4:8], FORMAT_VERSION)
+	return buf
+}
+
+// fileFormat reads the format of a file from its header, files without one predate versioning.
+func fileFormat(f io.ReaderAt, size int64) (format, error) {
+	if size == 0 {
+		return formatV1, nil
+	}
+	buf := make([]byte, FILE_HEADER_SIZE)
+	if size < FILE_HEADER_SIZE {
+		return formatV0, nil
+	}
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(buf[0:4], []byte(FILE_MAGIC)) {
+		return formatV0, nil
+	}
+	if version := binary.LittleEndian.Uint32(buf[4:8]); version != FORMAT_VERSION {
+		return 0, fmt.Errorf("%w (version %d)", ERR_UNSUPPORTED_VERSION, version)
+	}
+	return formatV1, nil
+}
+
+// scan walks every record from start. A record failing its checksum is handed to skip and stepped over
+// as long as its length fits in the file, with a nil skip the scan stops at it instead. It returns where
+// the last readable record ends, the error is nil only when the whole file was read.
+func scan(f io.ReaderAt, start, size int64, ft format, fn func(offset int64, r *Record), skip func(offset, length int64)) (int64, error) {
+	offset := start
+	for {
+		rec, length, err := readRecord(f, offset, size, ft)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err == ERR_CORRUPT_RECORD && skip != nil {
+			skip(offset, length)
+			offset += length
+			continue
+		}
+		if err != nil {
+			return offset, err
+		}
+		if fn != nil {
+			fn(offset, rec)
+		}
+		offset += length
+	}
+}
+
+// unversionedFormat tells the layout of a file without a header. The checksum is what tells v0 records
+// apart, so corrupt records are not skipped here: a file is only taken for the older layout when it
+// cannot be read as v0 at all but its first records can be read without checksums.
+func unversionedFormat(f io.ReaderAt, size int64) format {
+	if end, err := scan(f, 0, size, formatV0, nil, nil); err == nil || end > 0 {
+		return formatV0
+	}
+	if end, _ := scan(f, 0, size, formatLegacy, nil, nil); end > 0 {
+		return formatLegacy
+	}
+	return formatV0
+}
+
+// readRecord decodes the record at offset, size is the file size used to reject
+// lengths that cannot fit in the file or -1 when unknown.
+func readRecord(f io.ReaderAt, offset, size int64, ft format) (*Record, int64, error) {
+	headerSize := ft.headerSize()
+
+	if offset == size {
+		return nil, 0, io.EOF
+	}
+
+	buf := make([]byte, headerSize)
+	if n, err := f.ReadAt(buf, offset); err != nil {
+		if err == io.EOF && n == 0 {
+			return nil, 0, io.EOF
+		}
+		if err == io.EOF {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	var (
+		timestamp        int64
+		keyLen, valueLen int64
+		flags            byte
+		header           = buf
+	)
+	switch ft {
+	case formatLegacy:
+		timestamp = int64(binary.LittleEndian.Uint32(header[0:4])) * int64(time.Second)
+		keyLen = int64(binary.LittleEndian.Uint32(header[4:8]))
+		valueLen = int64(binary.LittleEndian.Uint32(header[8:12]))
+	case formatV0:
+		header = buf[4:]
+		timestamp = int64(binary.LittleEndian.Uint32(header[0:4])) * int64(time.Second)
+		keyLen = int64(binary.LittleEndian.Uint32(header[4:8]))
+		valueLen = int64(binary.LittleEndian.Uint32(header[8:12]))
+		// a value length that can never be written marked a tombstone before records had flags
+		if valueLen == TOMBSTONE {
+			flags, valueLen = FLAG_TOMBSTONE, 0
+		}
+	default:
+		header = buf[4:]
+		timestamp = int64(binary.LittleEndian.Uint64(header[0:8]))
+		flags = header[8]
+		keyLen = int64(binary.LittleEndian.Uint32(header[9:13]))
+		valueLen = int64(binary.LittleEndian.Uint32(header[13:17]))
+	}
+
+	length := headerSize + keyLen + valueLen
+	if size >= 0 && offset+length > size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, keyLen+valueLen)
+	if _, err := f.ReadAt(data, offset+headerSize); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	if ft != formatLegacy {
+		checksum := crc32.NewIEEE()
+		checksum.Write(header)
+		checksum.Write(data)
+		// the length is returned along with the error so the record can be skipped
+		if checksum.Sum32() != binary.LittleEndian.Uint32(buf[0:4]) {
+			return nil, length, ERR_CORRUPT_RECORD
+		}
+	}
+
+	if flags&^FLAG_TOMBSTONE != 0 {
+		return nil, 0, ERR_UNSUPPORTED_RECORD
+	}
+
+	return &Record{
+		Timestamp: timestamp,
+		Key:       data[:keyLen],
+		Value:     data[keyLen:],
+		tombstone: flags&FLAG_TOMBSTONE != 0,
+	}, length, nil
+}
+
+// recordSize is how many bytes the record takes in a segment.
+func recordSize(r *Record) uint32 {
+	return uint32(HEADER_SIZE + len(r.Key) + len(r.Value))
+}
+
+func recTobuf(r Record) []byte {
+	size := HEADER_SIZE + len(r.Key) + len(r.Value)
+	buf := make([]byte, size)
+
+	var flags byte
+	if r.tombstone {
+		flags |= FLAG_TOMBSTONE
+	}
+
+	// serialize the header for this record
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(r.Timestamp))
+	buf[12] = flags
+	binary.LittleEndian.PutUint32(buf[13:17], uint32(len(r.Key)))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(len(r.Value)))
+
+	copy(buf[HEADER_SIZE:HEADER_SIZE+len(r.Key)], r.Key)
+	copy(buf[HEADER_SIZE+len(r.Key):], r.Value)
+
+	// the checksum covers everything after itself
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
@@ -0,0 +1,603 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segment is one file of the store, records are only ever appended to the active one.
+type segment struct {
+	id   int
+	file *os.File
+	size int64
+	// dead is how many bytes of the segment compaction would drop
+	dead int64
+}
+
+// retainedRecord is a record kept by the retention policy along with where it is stored.
+type retainedRecord struct {
+	location
+	*Record
+}
+
+func segmentPath(base string, id int) string {
+	return fmt.Sprintf("%s.%06d", base, id)
+}
+
+// listSegments returns the ids of every segment file of the store in ascending order.
+func listSegments(base string) ([]int, error) {
+	matches, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, base+".")
+		id, err := strconv.Atoi(suffix)
+		if err != nil || id <= 0 || suffix != fmt.Sprintf("%06d", id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Segments returns the paths of the segment files at base, oldest first.
+func Segments(base string) ([]string, error) {
+	ids, err := listSegments(base)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(ids))
+	for _, id := range ids {
+		paths = append(paths, segmentPath(base, id))
+	}
+	return paths, nil
+}
+
+// openSegments indexes every segment oldest first, the newest one becomes the active segment.
+func (s *LogStore) openSegments() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := recoverMerge(s.path); err != nil {
+		return fmt.Errorf("cannot recover interrupted compaction: %v", err)
+	}
+
+	ids, err := listSegments(s.path)
+	if err != nil {
+		return err
+	}
+
+	// a store written before segments existed becomes the first segment
+	if len(ids) == 0 {
+		if _, err := os.Stat(s.path); err == nil {
+			if err := os.Rename(s.path, segmentPath(s.path, 1)); err != nil {
+				return err
+			}
+			os.Rename(hintPath(s.path), hintPath(segmentPath(s.path, 1)))
+		}
+		ids = []int{1}
+	}
+
+	for _, id := range ids {
+		seg, err := openSegment(s.path, id)
+		if err != nil {
+			return err
+		}
+		s.segments[id] = seg
+		s.active = seg
+		if err := s.indexSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openSegment(base string, id int) (*segment, error) {
+	file, err := os.OpenFile(segmentPath(base, id), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("error while open file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		if _, err := file.Write(fileHeader()); err != nil {
+			file.Close()
+			return nil, err
+		}
+		size = FILE_HEADER_SIZE
+	}
+	return &segment{id: id, file: file, size: size}, nil
+}
+
+func (s *LogStore) indexSegment(seg *segment) error {
+	ft, err := fileFormat(seg.file, seg.size)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", seg.file.Name(), err)
+	}
+	// files written before the format was versioned are rewritten in the current one
+	if ft != formatV1 {
+		return s.migrate(seg)
+	}
+
+	// the hint file covers the segment as it was after the last compaction, only the rest needs a full scan
+	start := max(s.loadHints(seg), FILE_HEADER_SIZE)
+
+	skip := skipCorrupt(seg)
+	end, scanErr := scan(seg.file, start, seg.size, formatV1, func(offset int64, r *Record) {
+		s.indexRecord(location{segment: seg.id, offset: offset, size: recordSize(r)}, r)
+	}, func(offset, length int64) {
+		skip(offset, length)
+		seg.dead += length
+	})
+	if scanErr == nil {
+		return nil
+	}
+	// a record from a newer kaido is not corruption, dropping it would lose data
+	if errors.Is(scanErr, ERR_UNSUPPORTED_RECORD) {
+		return fmt.Errorf("cannot open %s at offset %d: %v", seg.file.Name(), end, scanErr)
+	}
+
+	// only a torn tail is left, records failing their checksum before it were skipped
+	log.Printf("store %s is corrupt at offset %d, dropping the last %d bytes: %v\n", seg.file.Name(), end, seg.size-end, scanErr)
+	if err := seg.file.Truncate(end); err != nil {
+		return fmt.Errorf("error while truncating corrupt store: %v", err)
+	}
+	seg.size = end
+
+	return nil
+}
+
+// skipCorrupt logs a record of the segment that fails its checksum, the records after it are still read.
+func skipCorrupt(seg *segment) func(offset, length int64) {
+	return func(offset, length int64) {
+		log.Printf("store %s has a corrupt record at offset %d, skipping its %d bytes\n", seg.file.Name(), offset, length)
+	}
+}
+
+func (s *LogStore) indexRecord(loc location, r *Record) {
+	// tombstones are not counted as dead, compaction keeps them while older segments may need them
+	if r.tombstone {
+		s.unindex(string(r.Key))
+		return
+	}
+	s.index(string(r.Key), loc)
+}
+
+// migrate rewrites a segment without a file header in the current format.
+func (s *LogStore) migrate(seg *segment) error {
+	// files written before records had checksums cannot be read as v0 at all
+	ft := unversionedFormat(seg.file, seg.size)
+
+	var records []*Record
+	end, err := scan(seg.file, 0, seg.size, ft, func(offset int64, r *Record) {
+		records = append(records, r)
+	}, skipCorrupt(seg))
+	if err != nil {
+		log.Printf("store %s is corrupt at offset %d, dropping the last %d bytes: %v\n", seg.file.Name(), end, seg.size-end, err)
+	}
+
+	if _, err := s.rewrite(seg.id, records); err != nil {
+		return err
+	}
+	log.Printf("store %s upgraded to format version %d\n", seg.file.Name(), FORMAT_VERSION)
+
+	// tombstones are carried over, so the segment is indexed by scanning it like any other
+	return s.indexSegment(s.segments[seg.id])
+}
+
+// append writes the record at the end of the active segment, rolling over to a new segment once it is full.
+func (s *LogStore) append(r Record) (location, error) {
+	buf := recTobuf(r)
+	if s.active.size > FILE_HEADER_SIZE && s.active.size+int64(len(buf)) > s.opts.MaxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return location{}, fmt.Errorf("could not start a new segment: %v", err)
+		}
+	}
+
+	loc := location{segment: s.active.id, offset: s.active.size, size: uint32(len(buf))}
+	n, err := s.active.file.WriteAt(buf, s.active.size)
+	// a partial write is cut off on the next open, later records must not land after it
+	s.active.size += int64(n)
+	if err != nil {
+		return location{}, err
+	}
+	return loc, nil
+}
+
+// rotate seals the active segment and starts an empty one.
+func (s *LogStore) rotate() error {
+	if err := s.active.file.Sync(); err != nil {
+		return err
+	}
+	seg, err := openSegment(s.path, s.active.id+1)
+	if err != nil {
+		return err
+	}
+	s.segments[seg.id] = seg
+	s.active = seg
+	return nil
+}
+
+// sealed returns the ids of the immutable segments in ascending order.
+func (s *LogStore) sealed() []int {
+	var ids []int
+	for id := range s.segments {
+		if id != s.active.id {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Size is the total size in bytes of every segment.
+func (s *LogStore) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	return size
+}
+
+// Compact seals the active segment and merges every sealed segment regardless of how much space is wasted.
+func (s *LogStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ERR_CLOSED
+	}
+
+	if s.active.size > FILE_HEADER_SIZE {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	return s.compact(true)
+}
+
+// shouldCompact reports whether the share of the sealed segments taken by dropped records exceeds the threshold.
+func (s *LogStore) shouldCompact() bool {
+	var size, dead int64
+	for _, id := range s.sealed() {
+		seg := s.segments[id]
+		size += seg.size - FILE_HEADER_SIZE
+		dead += seg.dead
+	}
+	if size == 0 {
+		return false
+	}
+	return float64(dead)/float64(size) > s.threshold()
+}
+
+func (s *LogStore) threshold() float64 {
+	if s.opts.CompactThreshold <= 0 {
+		return DEFAULT_COMPACT_THRESHOLD
+	}
+	return s.opts.CompactThreshold
+}
+
+// compact merges the sealed segments whose share of dropped records exceeds the threshold, or every
+// sealed segment when all is set. Adjacent segments are merged together, the segments in between and
+// the active one are left untouched.
+func (s *LogStore) compact(all bool) error {
+	var runs [][]int
+	var run []int
+	for _, id := range s.sealed() {
+		seg := s.segments[id]
+		size := seg.size - FILE_HEADER_SIZE
+		if all || (size > 0 && float64(seg.dead)/float64(size) > s.threshold()) {
+			run = append(run, id)
+			continue
+		}
+		if len(run) > 0 {
+			runs = append(runs, run)
+			run = nil
+		}
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+
+	for _, run := range runs {
+		if err := s.merge(run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge rewrites a run of adjacent sealed segments into a fresh one holding only their retained records.
+// The output takes the place of the newest segment of the run so the log keeps its order. The merge is
+// recorded in a manifest before the output is put in place, a crash before the inputs are gone is
+// finished or rolled back on the next open instead of replaying their records twice.
+func (s *LogStore) merge(ids []int) error {
+	inRun := func(l location) bool {
+		return slices.Contains(ids, l.segment)
+	}
+
+	// only keys with a version in the run can lose one
+	retained := make(map[location]bool)
+	for key, locs := range s.history {
+		if slices.ContainsFunc(locs, inRun) {
+			for _, r := range s.retained(key) {
+				retained[r.location] = true
+			}
+		}
+	}
+	// older segments may still hold versions of deleted keys, their tombstones have to stay
+	keepTombstones := ids[0] != s.sealed()[0]
+
+	var records []*Record
+	var moved []location
+	for _, id := range ids {
+		seg := s.segments[id]
+		_, err := scan(seg.file, FILE_HEADER_SIZE, seg.size, formatV1, func(offset int64, r *Record) {
+			loc := location{segment: id, offset: offset, size: recordSize(r)}
+			if retained[loc] || (r.tombstone && keepTombstones) {
+				records = append(records, r)
+				moved = append(moved, loc)
+			}
+		}, func(offset, length int64) {})
+		if err != nil {
+			return fmt.Errorf("cannot compact %s: %v", seg.file.Name(), err)
+		}
+	}
+
+	target := ids[len(ids)-1]
+	inputs := ids
+	var out *written
+	if len(records) > 0 {
+		var err error
+		if out, err = s.writeSegment(records); err != nil {
+			return err
+		}
+		inputs = ids[:len(ids)-1]
+	}
+
+	if err := writeManifest(s.path, out, ids); err != nil {
+		if out != nil {
+			os.Remove(out.path)
+		}
+		return err
+	}
+	if out != nil {
+		if err := s.installSegment(target, out); err != nil {
+			os.Remove(out.path)
+			os.Remove(manifestPath(s.path))
+			return err
+		}
+	}
+	if err := s.removeSegments(inputs); err != nil {
+		return err
+	}
+	if err := os.Remove(manifestPath(s.path)); err != nil {
+		return err
+	}
+
+	// point the index at where the retained records were moved to
+	next := make(map[location]location, len(moved))
+	for i, loc := range moved {
+		next[loc] = location{segment: target, offset: out.hints[i].offset, size: out.hints[i].size}
+	}
+	for key, locs := range s.history {
+		if !slices.ContainsFunc(locs, inRun) {
+			continue
+		}
+		var kept []location
+		for _, loc := range locs {
+			if !inRun(loc) {
+				kept = append(kept, loc)
+			} else if n, exists := next[loc]; exists {
+				kept = append(kept, n)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.storage, key)
+			delete(s.history, key)
+			continue
+		}
+		s.history[key] = kept
+		s.storage[key] = kept[len(kept)-1]
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// removeSegments closes and deletes the segments along with their hint files.
+func (s *LogStore) removeSegments(ids []int) error {
+	for _, id := range ids {
+		seg := s.segments[id]
+		seg.file.Close()
+		delete(s.segments, id)
+		os.Remove(hintPath(seg.file.Name()))
+		if err := os.Remove(seg.file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// written is a segment file that is fully synced but not in place yet.
+type written struct {
+	path string
+	// hints locates every record in the file in the order they were given
+	hints   []hint
+	size    int64
+	deletes bool
+}
+
+// rewrite replaces the segment with the given records and returns where they were written. The new file
+// is fully synced before it atomically takes the place of the old one, so a crash leaves either the old
+// or the new file behind.
+func (s *LogStore) rewrite(id int, records []*Record) ([]hint, error) {
+	out, err := s.writeSegment(records)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.installSegment(id, out); err != nil {
+		os.Remove(out.path)
+		return nil, err
+	}
+	return out.hints, nil
+}
+
+// writeSegment writes the records to a temporary file next to the segments.
+func (s *LogStore) writeSegment(records []*Record) (*written, error) {
+	dir, err := filepath.Abs(filepath.Dir(s.path))
+	if err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(dir, "temp-*.db")
+	if err != nil {
+		return nil, err
+	}
+	out := &written{path: temp.Name(), hints: make([]hint, 0, len(records))}
+	// only does something when bailing out before the file is complete
+	ok := false
+	defer func() {
+		if !ok {
+			temp.Close()
+			os.Remove(out.path)
+		}
+	}()
+
+	w := bufio.NewWriter(temp)
+	if _, err := w.Write(fileHeader()); err != nil {
+		return nil, err
+	}
+
+	offset := int64(FILE_HEADER_SIZE)
+	for _, r := range records {
+		buf := recTobuf(*r)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		out.hints = append(out.hints, hint{key: string(r.Key), offset: offset, size: uint32(len(buf))})
+		offset += int64(len(buf))
+		out.deletes = out.deletes || r.tombstone
+	}
+	out.size = offset
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := temp.Sync(); err != nil {
+		return nil, err
+	}
+	if err := temp.Close(); err != nil {
+		return nil, err
+	}
+	ok = true
+	return out, nil
+}
+
+// installSegment atomically puts a written file in the place of the segment.
+func (s *LogStore) installSegment(id int, out *written) error {
+	segPath, err := filepath.Abs(segmentPath(s.path, id))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(segPath)
+
+	// a stale hint file must not describe the new segment
+	os.Remove(hintPath(segPath))
+	if err := os.Rename(out.path, segPath); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
+	seg, err := openSegment(s.path, id)
+	if err != nil {
+		return err
+	}
+	if old, exists := s.segments[id]; exists {
+		old.file.Close()
+	}
+	s.segments[id] = seg
+	if s.active.id == id {
+		s.active = seg
+	}
+
+	// hints cannot express deletes, a segment with tombstones is scanned on open instead.
+	// Otherwise the store is already consistent and a missing hint file only makes the next open slower
+	if !out.deletes {
+		if err := writeHints(hintPath(segPath), out.size, out.hints); err != nil {
+			log.Printf("cannot write hint file for %s: %v\n", segPath, err)
+		}
+	}
+	return nil
+}
+
+// syncDir makes a rename inside dir durable.
+func syncDir(dir string) error {
+	// windows cannot fsync a directory, renames are durable there once they return
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// retained applies the retention policy to the history of a key.
+func (s *LogStore) retained(key string) []retainedRecord {
+	locs := s.history[key]
+	// internal keys are bookkeeping, only their latest value matters
+	if _, name := SplitNamespace(key); strings.HasPrefix(name, INTERNAL_PREFIX) {
+		locs = locs[len(locs)-1:]
+	}
+
+	var records []retainedRecord
+	for _, loc := range locs {
+		record, err := s.deserialize(loc)
+		if err != nil {
+			continue
+		}
+		// an unchanged value is not a new snapshot, keep the time it was first seen
+		if len(records) > 0 && bytes.Equal(records[len(records)-1].Value, record.Value) {
+			continue
+		}
+		records = append(records, retainedRecord{loc, record})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	latest := records[len(records)-1]
+	if n := s.opts.Retention.MaxVersions; n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+	if maxAge := s.opts.Retention.MaxAge; maxAge > 0 {
+		cutoff := time.Now().Add(-maxAge).UnixNano()
+		records = slices.DeleteFunc(records, func(r retainedRecord) bool {
+			return r.location != latest.location && r.Timestamp < cutoff
+		})
+	}
+	return records
+}
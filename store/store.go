@@ -1,53 +1,147 @@
 package store
 
 import (
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"iter"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	HEADER_SIZE = 12 // Timestamp = 4 bytes, Key = 4 bytes, Value = 4 bytes
+	FILE_MAGIC       = "KAID"
+	FILE_HEADER_SIZE = 8 // Magic = 4 bytes, Version = 4 bytes
+	FORMAT_VERSION   = 1
+
+	HEADER_SIZE        = 21 // Checksum = 4 bytes, Timestamp = 8 bytes, Flags = 1 byte, Key = 4 bytes, Value = 4 bytes
+	V0_HEADER_SIZE     = 16 // Checksum = 4 bytes, Timestamp = 4 bytes, Key = 4 bytes, Value = 4 bytes
+	LEGACY_HEADER_SIZE = 12 // Timestamp = 4 bytes, Key = 4 bytes, Value = 4 bytes
+	INTERNAL_PREFIX    = "__"
+
+	FLAG_TOMBSTONE = 1 << 0
+	// FLAG_COMPRESSED is reserved for compressed values, records carrying it are not readable yet
+	FLAG_COMPRESSED = 1 << 1
+
+	BACKEND_LOG    = "log"
+	BACKEND_MEMORY = "memory"
+
+	DEFAULT_COMPACT_THRESHOLD = 0.5
+	DEFAULT_SEGMENT_SIZE      = 64 << 20
+
+	// the value length that marked a tombstone in v0 files
+	TOMBSTONE = math.MaxUint32
 )
 
 var (
-	ERR_KEY_NOT_FOUND = errors.New("could not find record with this key")
+	ERR_KEY_NOT_FOUND  = errors.New("could not find record with this key")
+	ERR_CORRUPT_RECORD = errors.New("record is corrupt")
+	ERR_CLOSED         = errors.New("store is closed")
 )
 
 type Record struct {
-	Timestamp uint32
+	// Timestamp is when the record was written in nanoseconds since the unix epoch
+	Timestamp int64
 	Key       []byte
 	Value     []byte
+	// tombstone marks the key as deleted from this record on
+	tombstone bool
+}
+
+// Store is a key value store that keeps every version of a key.
+type Store interface {
+	Get(key string) (*Record, error)
+	Put(r Record) error
+	// Delete removes the key with all of its versions
+	Delete(key string) error
+	// DeletePrefix deletes every key starting with prefix and returns how many were deleted
+	DeletePrefix(prefix string) (int, error)
+	// Keys returns every stored key in sorted order
+	Keys() []string
+	// Scan iterates over the latest record of every key starting with prefix, in sorted key order
+	Scan(prefix string) iter.Seq2[*Record, error]
+	// History returns every retained version of the key, oldest first
+	History(key string) ([]*Record, error)
+	Close() error
+}
+
+// Compacter is implemented by stores that can reclaim the space of dropped records.
+type Compacter interface {
+	Compact() error
+	// Size is how many bytes the store takes on disk
+	Size() int64
+}
+
+// Retention limits how many old versions of a key survive compaction, zero values keep everything.
+// The latest version of a key is always kept.
+type Retention struct {
+	MaxAge      time.Duration
+	MaxVersions int
+}
+
+type Options struct {
+	Retention Retention
+	// CompactThreshold is the share of dead bytes in the sealed segments above which Close compacts them
+	CompactThreshold float64
+	// MaxSegmentSize is the size in bytes after which writes roll over to a new segment
+	MaxSegmentSize int64
+	// Wait makes GetInstance block while another process holds the workspace lock
+	Wait bool
+	// Backend picks the implementation opened by GetInstance, default to the log store
+	Backend string
+	// Path is the base path of the segment files opened by GetInstance, default to ~/.kaido/store.db
+	Path string
+}
+
+// location points at a record inside one of the segments.
+type location struct {
+	segment int
+	offset  int64
+	size    uint32
 }
 
-type Store struct {
-	storage map[string]int64
-	mu      sync.RWMutex
-	file    *os.File
+type LogStore struct {
+	// storage points at the latest version of each key, history at every version oldest first
+	storage  map[string]location
+	history  map[string][]location
+	path     string
+	segments map[int]*segment
+	// active is the only segment that is written to, every other one is immutable
+	active *segment
+	opts   Options
+	mu     sync.RWMutex
+	// closed is set once the files are released, every later call fails with ERR_CLOSED
+	closed bool
 }
 
 var (
-	mu       = sync.Mutex{}
-	instance *Store
-	once     sync.Once
+	mu        = sync.Mutex{}
+	instance  Store
+	once      sync.Once
+	options   Options
+	workspace *lock
 )
 
-func GetInstance() (*Store, error) {
+// Configure sets the options used when the shared instance is opened.
+func Configure(opts Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	options = opts
+}
+
+// GetInstance opens the shared instance on first use, ctx cancels waiting for the workspace lock.
+func GetInstance(ctx context.Context) (Store, error) {
 	if instance == nil {
 		mu.Lock()
 		defer mu.Unlock()
 		// more if check for instance to ensure no more than 1 goroutine bypass the first check
 		if instance == nil {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return nil, err
-			}
-			dbDir := fmt.Sprintf("%s/.kaido/store.db", homeDir)
-			store, err := open(dbDir)
+			store, err := openInstance(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -57,175 +151,351 @@ func GetInstance() (*Store, error) {
 	return instance, nil
 }
 
-func open(path string) (*Store, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+func openInstance(ctx context.Context) (Store, error) {
+	switch options.Backend {
+	case "", BACKEND_LOG:
+	case BACKEND_MEMORY:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", options.Backend)
+	}
+
+	dbDir, err := storePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dbDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// only one process at a time may append to or compact the store
+	l, err := lockWorkspace(ctx, filepath.Dir(dbDir), options.Wait)
+	if err != nil {
+		return nil, err
+	}
+	store, err := open(dbDir, options)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	workspace = l
+	return store, nil
+}
+
+// CloseInstance closes the shared instance if it was ever opened and unlocks the workspace.
+func CloseInstance() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil {
+		return nil
+	}
+	err := instance.Close()
+	instance = nil
+	if workspace != nil {
+		workspace.release()
+		workspace = nil
+	}
+	return err
+}
+
+// Path is the base path of the segment files used by the shared instance.
+func Path() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return storePath()
+}
+
+func storePath() (string, error) {
+	if len(options.Path) > 0 {
+		return options.Path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("error while open file: %v\n", err)
+		return "", err
 	}
+	return fmt.Sprintf("%s/.kaido/store.db", homeDir), nil
+}
 
-	store := &Store{
-		storage: make(map[string]int64),
-		mu:      sync.RWMutex{},
-		file:    file,
+func open(path string, opts Options) (*LogStore, error) {
+	if opts.MaxSegmentSize <= 0 {
+		opts.MaxSegmentSize = DEFAULT_SEGMENT_SIZE
 	}
 
-	if err := store.generateIndex(); err != nil {
-		return nil, err
+	store := &LogStore{
+		storage:  make(map[string]location),
+		history:  make(map[string][]location),
+		path:     path,
+		segments: make(map[int]*segment),
+		opts:     opts,
+		mu:       sync.RWMutex{},
+	}
+
+	if err := store.openSegments(); err != nil {
+		store.release()
+		return nil, fmt.Errorf("error while open store: %v", err)
 	}
 
 	return store, nil
 }
 
-func (s *Store) Close() error {
+// Close compacts the sealed segments taken up by dropped records when there are enough of them and releases the files.
+func (s *LogStore) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.file != nil {
-		defer s.file.Close()
-		return s.compact()
+	if s.closed {
+		return nil
+	}
+	defer s.release()
+
+	if s.shouldCompact() {
+		return s.compact(false)
 	}
 	return nil
 }
 
-func (s *Store) Get(key string) (*Record, error) {
+// release closes every segment file without compacting, the index goes with them.
+func (s *LogStore) release() {
+	for _, seg := range s.segments {
+		seg.file.Close()
+	}
+	clear(s.segments)
+	clear(s.storage)
+	clear(s.history)
+	s.active = nil
+	s.closed = true
+}
+
+func (s *LogStore) Get(key string) (*Record, error) {
 	if len(key) == 0 {
 		return nil, fmt.Errorf("key cannot be empty")
 	}
 
 	s.mu.RLock()
-	offset, exists := s.storage[key]
-	s.mu.RUnlock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ERR_CLOSED
+	}
 
+	loc, exists := s.storage[key]
 	if !exists {
 		return nil, ERR_KEY_NOT_FOUND
 	}
 
-	return s.deserialize(offset)
+	return s.deserialize(loc)
 }
 
-func (s *Store) Put(r Record) error {
+func (s *LogStore) Put(r Record) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	// gets the offset of the new record
-	offset, err := s.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("could not set offset: %v", err)
+	if s.closed {
+		return ERR_CLOSED
 	}
 
-	// writes new value at the very end
-	if _, err = s.file.Write(recTobuf(r)); err != nil {
+	loc, err := s.append(r)
+	if err != nil {
 		return fmt.Errorf("error while writing new value: %v", err)
 	}
 
-	s.storage[string(r.Key)] = offset
+	s.index(string(r.Key), loc)
 	return nil
 }
 
-func (s *Store) generateIndex() error {
+// Delete appends a tombstone for the key so it is gone from the index now and from the segments after compaction.
+func (s *LogStore) Delete(key string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return ERR_CLOSED
+	}
+	return s.delete(key)
+}
 
-	var offset int64
-	for {
-		rec, err := s.deserialize(offset)
-		if err == io.EOF {
-			break
-		}
+// DeletePrefix deletes every key starting with prefix and returns how many were deleted.
+func (s *LogStore) DeletePrefix(prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ERR_CLOSED
+	}
 
-		if err != nil {
-			return fmt.Errorf("error while generating index: %v", err)
+	keys := s.keys(prefix)
+	for i, key := range keys {
+		if err := s.delete(key); err != nil {
+			return i, err
 		}
-
-		s.storage[string(rec.Key)] = offset
-		offset += int64(HEADER_SIZE + len(rec.Key) + len(rec.Value))
 	}
+	return len(keys), nil
+}
 
-	return nil
+// Keys returns every stored key in sorted order.
+func (s *LogStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys("")
 }
 
-func (s *Store) compact() error {
-	storePath, err := filepath.Abs(s.file.Name())
-	if err != nil {
-		return err
-	}
+// Scan iterates over the latest record of every key starting with prefix, in sorted key order.
+func (s *LogStore) Scan(prefix string) iter.Seq2[*Record, error] {
+	s.mu.RLock()
+	keys := s.keys(prefix)
+	s.mu.RUnlock()
+	return s.iterate(keys)
+}
 
-	dir := filepath.Dir(storePath)
+// Range iterates over the latest record of every key in [start, end) in sorted key order,
+// an empty end means no upper bound.
+func (s *LogStore) Range(start, end string) iter.Seq2[*Record, error] {
+	s.mu.RLock()
+	keys := s.keys("")
+	s.mu.RUnlock()
 
-	temp, err := os.CreateTemp(dir, "temp-*.db")
-	if err != nil {
-		return err
+	from, _ := slices.BinarySearch(keys, start)
+	to := len(keys)
+	if len(end) > 0 {
+		to, _ = slices.BinarySearch(keys, end)
 	}
-	defer temp.Close()
-
-	_, err = temp.Seek(0, io.SeekEnd)
-	if err != nil {
-		return err
+	if from > to {
+		from = to
 	}
+	return s.iterate(keys[from:to])
+}
 
-	// write recent records from store to temp file
-	for _, offset := range s.storage {
-		record, err := s.deserialize(offset)
-		if err != nil {
-			continue
+// iterate looks every key up again right before yielding it, keys deleted in the meantime are skipped.
+func (s *LogStore) iterate(keys []string) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		for _, key := range keys {
+			r, err := s.Get(key)
+			if err == ERR_KEY_NOT_FOUND {
+				continue
+			}
+			if !yield(r, err) {
+				return
+			}
 		}
+	}
+}
 
-		if _, err = temp.Write(recTobuf(*record)); err != nil {
-			return err
+func (s *LogStore) keys(prefix string) []string {
+	var keys []string
+	for key := range s.storage {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
 		}
 	}
+	slices.Sort(keys)
+	return keys
+}
 
-	err = os.Remove(storePath)
-	if err != nil {
-		return err
+func (s *LogStore) delete(key string) error {
+	if _, exists := s.storage[key]; !exists {
+		return ERR_KEY_NOT_FOUND
 	}
 
-	err = os.Rename(temp.Name(), s.file.Name())
-	if err != nil {
-		return err
+	tombstone := Record{
+		Timestamp: time.Now().UnixNano(),
+		Key:       []byte(key),
+		tombstone: true,
 	}
 
+	if _, err := s.append(tombstone); err != nil {
+		return fmt.Errorf("error while writing tombstone: %v", err)
+	}
+
+	s.unindex(key)
 	return nil
 }
 
-func (s *Store) deserialize(offset int64) (*Record, error) {
-	var timestamp, keyLen, valueLen uint32
-	record := &Record{}
-	buf := make([]byte, HEADER_SIZE)
-
-	if _, err := s.file.ReadAt(buf, offset); err != nil {
-		return nil, err
+// History returns every retained version of the key, oldest first.
+func (s *LogStore) History(key string) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ERR_CLOSED
 	}
 
-	timestamp = binary.LittleEndian.Uint32(buf[0:4])
-	keyLen = binary.LittleEndian.Uint32(buf[4:8])
-	valueLen = binary.LittleEndian.Uint32(buf[8:12])
+	locs := s.history[key]
+	if len(locs) == 0 {
+		return nil, ERR_KEY_NOT_FOUND
+	}
 
-	record.Timestamp = timestamp
+	records := make([]*Record, 0, len(locs))
+	for _, loc := range locs {
+		r, err := s.deserialize(loc)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
 
-	key := make([]byte, keyLen)
-	if _, err := s.file.ReadAt(key, HEADER_SIZE+offset); err != nil {
+// At returns the version of the key that was current at the given time.
+func At(s Store, key string, t time.Time) (*Record, error) {
+	records, err := s.History(key)
+	if err != nil {
 		return nil, err
 	}
-	record.Key = key
 
-	value := make([]byte, valueLen)
-	if _, err := s.file.ReadAt(value, HEADER_SIZE+offset+int64(keyLen)); err != nil {
-		return nil, err
+	var found *Record
+	for _, r := range records {
+		if r.Timestamp > t.UnixNano() {
+			break
+		}
+		found = r
 	}
-	record.Value = value
-	return record, nil
+	if found == nil {
+		return nil, ERR_KEY_NOT_FOUND
+	}
+	return found, nil
 }
 
-func recTobuf(r Record) []byte {
-	size := HEADER_SIZE + len(r.Key) + len(r.Value)
-	buf := make([]byte, size)
+// index makes loc the latest version of the key, versions the retention policy no longer keeps
+// because of it are counted as dead.
+func (s *LogStore) index(key string, loc location) {
+	prev := s.history[key]
+	from := len(prev) - s.kept(key, len(prev))
+	locs := append(prev, loc)
+	for _, l := range locs[from : len(locs)-s.kept(key, len(locs))] {
+		s.drop(l)
+	}
+	s.storage[key] = loc
+	s.history[key] = locs
+}
 
-	// serialize the header for this record
-	binary.LittleEndian.PutUint32(buf[0:4], r.Timestamp)
-	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(r.Key)))
-	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(r.Value)))
+func (s *LogStore) unindex(key string) {
+	locs := s.history[key]
+	for _, l := range locs[len(locs)-s.kept(key, len(locs)):] {
+		s.drop(l)
+	}
+	delete(s.storage, key)
+	delete(s.history, key)
+}
 
-	copy(buf[HEADER_SIZE:HEADER_SIZE+len(r.Key)], r.Key)
-	copy(buf[HEADER_SIZE+len(r.Key):], r.Value)
-	return buf
+// kept is how many of the latest versions of a key survive compaction. Versions expiring with
+// MaxAge are left out, they are only dropped once compaction runs for other reasons.
+func (s *LogStore) kept(key string, versions int) int {
+	// internal keys are bookkeeping, only their latest value matters
+	if _, name := SplitNamespace(key); strings.HasPrefix(name, INTERNAL_PREFIX) {
+		return min(versions, 1)
+	}
+	if n := s.opts.Retention.MaxVersions; n > 0 {
+		return min(versions, n)
+	}
+	return versions
+}
+
+// drop counts the record at loc as dead space of its segment.
+func (s *LogStore) drop(loc location) {
+	if seg, exists := s.segments[loc.segment]; exists {
+		seg.dead += int64(loc.size)
+	}
+}
+
+func (s *LogStore) deserialize(loc location) (*Record, error) {
+	seg, exists := s.segments[loc.segment]
+	if !exists {
+		return nil, fmt.Errorf("segment %d is missing", loc.segment)
+	}
+	r, _, err := readRecord(seg.file, loc.offset, -1, formatV1)
+	return r, err
 }
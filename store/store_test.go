@@ -1,10 +1,17 @@
 package store
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpenStore(t *testing.T) {
@@ -23,7 +30,7 @@ func TestOpenStore(t *testing.T) {
 	}
 
 	storePath := path.Join(outDir, "kaido.store")
-	store, err := open(storePath)
+	store, err := open(storePath, Options{})
 	if err != nil {
 		t.Fatalf("error while initializing store file: %v\n", err)
 	}
@@ -38,7 +45,7 @@ func TestWrite(t *testing.T) {
 	parentDir := filepath.Dir(dir)
 	outDir := path.Join(parentDir, "/.out")
 	storePath := path.Join(outDir, "kaido.store")
-	store, err := open(storePath)
+	store, err := open(storePath, Options{})
 	if err != nil {
 		t.Fatalf("error while initializing store file: %v\n", err)
 	}
@@ -65,3 +72,863 @@ func TestWrite(t *testing.T) {
 		t.Fatal("record should not be nil")
 	}
 }
+
+// tempStore opens a fresh store that is removed once the test is done.
+func tempStore(t *testing.T) *LogStore {
+	t.Helper()
+	store, err := open(path.Join(t.TempDir(), "kaido.store"), Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestOutbox(t *testing.T) {
+	store := NewMemoryStore()
+
+	for _, key := range []string{"a", "b", "a"} {
+		if err := Enqueue(store, key, []byte(`{}`)); err != nil {
+			t.Fatalf("error while enqueueing %s: %v\n", key, err)
+		}
+	}
+
+	pending, err := Pending(store)
+	if err != nil {
+		t.Fatalf("error while reading pending entries: %v\n", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("duplicate keys should be ignored, got %d pending entries\n", len(pending))
+	}
+
+	if err := MarkDelivered(store, "a"); err != nil {
+		t.Fatalf("error while marking entry delivered: %v\n", err)
+	}
+
+	// delivered keys are remembered so the same announcement is not queued again
+	if err := Enqueue(store, "a", []byte(`{}`)); err != nil {
+		t.Fatalf("error while enqueueing a: %v\n", err)
+	}
+
+	pending, err = Pending(store)
+	if err != nil {
+		t.Fatalf("error while reading pending entries: %v\n", err)
+	}
+	if len(pending) != 1 || pending[0].Key != "b" {
+		t.Fatalf("expected only b to be pending, got %v\n", pending)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{Retention: Retention{MaxVersions: 2}})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	for i, value := range []string{"first", "second", "third"} {
+		err := store.Put(Record{
+			Timestamp: time.Unix(int64(1000*(i+1)), 0).UnixNano(),
+			Key:       []byte("akina-downhill"),
+			Value:     []byte(value),
+		})
+		if err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+
+	rec, err := At(store, "akina-downhill", time.Unix(2500, 0))
+	if err != nil {
+		t.Fatalf("error getting record at time: %v\n", err)
+	}
+	if string(rec.Value) != "second" {
+		t.Fatalf("expected second version, got %s\n", rec.Value)
+	}
+
+	// compaction drops versions beyond the retention policy
+	if err := store.Compact(); err != nil {
+		t.Fatalf("error while compacting store: %v\n", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("error while closing store: %v\n", err)
+	}
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+
+	records, err := store.History("akina-downhill")
+	if err != nil {
+		t.Fatalf("error getting history: %v\n", err)
+	}
+	if len(records) != 2 || string(records[0].Value) != "second" || string(records[1].Value) != "third" {
+		t.Fatalf("expected the 2 latest versions to be retained, got %d\n", len(records))
+	}
+}
+
+func TestRecoverTornWrite(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	for _, key := range []string{"akina-downhill", "usui-uphill"} {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte("[]")}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	store.release()
+
+	// simulate a crash in the middle of writing a record
+	segPath := segmentPath(storePath, 1)
+	valid, _ := os.Stat(segPath)
+	torn := recTobuf(Record{Key: []byte("myogi-downhill"), Value: []byte("[]")})
+	f, _ := os.OpenFile(segPath, os.O_APPEND|os.O_WRONLY, 0666)
+	f.Write(torn[:len(torn)-3])
+	f.Close()
+
+	report, err := Verify(segPath)
+	if err != nil {
+		t.Fatalf("error while verifying store: %v\n", err)
+	}
+	if report.Ok() || report.Valid != valid.Size() || report.Records != 2 {
+		t.Fatalf("expected the torn record to be reported, got %+v\n", report)
+	}
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("store with a torn tail should still open: %v\n", err)
+	}
+	defer store.Close()
+
+	if _, err := store.Get("usui-uphill"); err != nil {
+		t.Fatalf("records before the torn write should survive: %v\n", err)
+	}
+	if info, _ := os.Stat(segPath); info.Size() != valid.Size() {
+		t.Fatalf("expected store to be truncated to %d bytes, got %d\n", valid.Size(), info.Size())
+	}
+}
+
+func TestMigrateLegacyStore(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+
+	// header without a checksum: timestamp, key length, value length
+	key, value := []byte("akina-downhill"), []byte("[]")
+	buf := make([]byte, LEGACY_HEADER_SIZE+len(key)+len(value))
+	binary.LittleEndian.PutUint32(buf[0:4], 1000)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(value)))
+	copy(buf[LEGACY_HEADER_SIZE:], key)
+	copy(buf[LEGACY_HEADER_SIZE+len(key):], value)
+	if err := os.WriteFile(storePath, buf, 0666); err != nil {
+		t.Fatalf("error while writing legacy store: %v\n", err)
+	}
+
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while opening legacy store: %v\n", err)
+	}
+	defer store.Close()
+
+	rec, err := store.Get("akina-downhill")
+	if err != nil {
+		t.Fatalf("legacy record should be readable after migration: %v\n", err)
+	}
+	if rec.Timestamp != time.Unix(1000, 0).UnixNano() || string(rec.Value) != "[]" {
+		t.Fatalf("legacy record changed during migration: %+v\n", rec)
+	}
+}
+
+func TestMigrateTornLegacyStore(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+
+	legacy := func(key, value string) []byte {
+		buf := make([]byte, LEGACY_HEADER_SIZE+len(key)+len(value))
+		binary.LittleEndian.PutUint32(buf[0:4], 1000)
+		binary.LittleEndian.PutUint32(buf[4:8], uint32(len(key)))
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(len(value)))
+		copy(buf[LEGACY_HEADER_SIZE:], key)
+		copy(buf[LEGACY_HEADER_SIZE+len(key):], value)
+		return buf
+	}
+	// two complete records followed by one that was cut off while writing
+	data := append(legacy("akina-downhill", "[]"), legacy("usui-uphill", "[]")...)
+	torn := legacy("myogi-downhill", "[]")
+	data = append(data, torn[:len(torn)-3]...)
+	if err := os.WriteFile(storePath, data, 0666); err != nil {
+		t.Fatalf("error while writing legacy store: %v\n", err)
+	}
+
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while opening legacy store: %v\n", err)
+	}
+	defer store.Close()
+
+	if keys := store.Keys(); !slices.Equal(keys, []string{"akina-downhill", "usui-uphill"}) {
+		t.Fatalf("records before the torn tail should survive the migration, got %v\n", keys)
+	}
+}
+
+func TestSkipCorruptRecord(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	for _, key := range []string{"akina-downhill", "usui-uphill", "myogi-downhill"} {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte("[]")}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	store.release()
+
+	// flip a byte in the value of the record in the middle
+	segPath := segmentPath(storePath, 1)
+	valid, _ := os.Stat(segPath)
+	record := int64(HEADER_SIZE + len("akina-downhill") + 2)
+	f, _ := os.OpenFile(segPath, os.O_RDWR, 0666)
+	f.WriteAt([]byte("{"), FILE_HEADER_SIZE+record+HEADER_SIZE+int64(len("usui-uphill")))
+	f.Close()
+
+	report, err := Verify(segPath)
+	if err != nil {
+		t.Fatalf("error while verifying store: %v\n", err)
+	}
+	if report.Ok() || report.Corrupt != 1 || report.Records != 2 || report.Valid != valid.Size() {
+		t.Fatalf("expected the corrupt record to be reported, got %+v\n", report)
+	}
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("store with a corrupt record should still open: %v\n", err)
+	}
+	defer store.Close()
+
+	if keys := store.Keys(); !slices.Equal(keys, []string{"akina-downhill", "myogi-downhill"}) {
+		t.Fatalf("records after the corrupt one should survive, got %v\n", keys)
+	}
+	if info, _ := os.Stat(segPath); info.Size() != valid.Size() {
+		t.Fatalf("a corrupt record must not truncate the store, expected %d bytes, got %d\n", valid.Size(), info.Size())
+	}
+}
+
+func TestCompactOnlyAboveThreshold(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	// every record gets its own segment so the dead space of the sealed ones is easy to count
+	store, err := open(storePath, Options{Retention: Retention{MaxVersions: 1}, CompactThreshold: 0.6, MaxSegmentSize: 1})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	put := func(key, value string) {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte(value)}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	put("akina-downhill", "first")
+	put("usui-uphill", "first")
+	put("akina-downhill", "second")
+
+	if store.shouldCompact() {
+		t.Fatal("half of dead space should not trigger compaction")
+	}
+
+	put("akina-downhill", "third")
+	put("akina-downhill", "fourth")
+
+	if !store.shouldCompact() {
+		t.Fatal("three quarters of dead space should trigger compaction")
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("error while closing store: %v\n", err)
+	}
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+
+	records, err := store.History("akina-downhill")
+	if err != nil || len(records) != 1 || string(records[0].Value) != "fourth" {
+		t.Fatalf("expected only the latest version after compaction, got %d: %v\n", len(records), err)
+	}
+	temps, _ := filepath.Glob(path.Join(filepath.Dir(storePath), "temp-*"))
+	if len(temps) != 0 {
+		t.Fatalf("temporary compaction files should not be left behind, got %v\n", temps)
+	}
+}
+
+func TestCompactOnlyDeadSegments(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{Retention: Retention{MaxVersions: 1}, CompactThreshold: 0.3, MaxSegmentSize: 1})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	for _, r := range []string{"akina-downhill=first", "usui-uphill=first", "akina-downhill=second", "myogi-downhill=first"} {
+		key, value, _ := strings.Cut(r, "=")
+		if err := store.Put(Record{Key: []byte(key), Value: []byte(value)}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+
+	live, _ := os.Stat(segmentPath(storePath, 2))
+	if err := store.Close(); err != nil {
+		t.Fatalf("error while closing store: %v\n", err)
+	}
+
+	// only the first segment is dead, the others keep their files
+	if _, err := os.Stat(segmentPath(storePath, 1)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the dead segment should be removed, got %v\n", err)
+	}
+	if info, err := os.Stat(segmentPath(storePath, 2)); err != nil || !os.SameFile(live, info) {
+		t.Fatalf("a segment under the threshold should not be rewritten: %v\n", err)
+	}
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+	if keys := store.Keys(); !slices.Equal(keys, []string{"akina-downhill", "myogi-downhill", "usui-uphill"}) {
+		t.Fatalf("unexpected keys after compaction: %v\n", keys)
+	}
+	if records, err := store.History("akina-downhill"); err != nil || len(records) != 1 || string(records[0].Value) != "second" {
+		t.Fatalf("expected only the latest version after compaction, got %d: %v\n", len(records), err)
+	}
+}
+
+func TestRecoverInterruptedMerge(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{MaxSegmentSize: 1})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	for _, r := range []string{"akina-downhill=first", "usui-uphill=first", "akina-downhill=second"} {
+		key, value, _ := strings.Cut(r, "=")
+		if err := store.Put(Record{Key: []byte(key), Value: []byte(value)}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	store.release()
+
+	original := make(map[int][]byte)
+	for _, id := range []int{1, 2, 3} {
+		original[id], _ = os.ReadFile(segmentPath(storePath, id))
+	}
+
+	// every segment is merged into the third one
+	store, err = open(storePath, Options{MaxSegmentSize: 1})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatalf("error while compacting store: %v\n", err)
+	}
+	store.release()
+	merged, _ := os.ReadFile(segmentPath(storePath, 3))
+
+	check := func(state string) {
+		store, err := open(storePath, Options{})
+		if err != nil {
+			t.Fatalf("%s: error while reopening store file: %v\n", state, err)
+		}
+		defer store.release()
+		if records, err := store.History("akina-downhill"); err != nil || len(records) != 2 {
+			t.Fatalf("%s: expected the 2 versions once, got %d: %v\n", state, len(records), err)
+		}
+		if _, err := os.Stat(manifestPath(storePath)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: the manifest should be gone, got %v\n", state, err)
+		}
+		temps, _ := filepath.Glob(path.Join(filepath.Dir(storePath), "temp-*"))
+		if len(temps) != 0 {
+			t.Fatalf("%s: temporary files should not be left behind, got %v\n", state, temps)
+		}
+	}
+
+	// crash after the output took the place of the third segment but before the others were removed
+	os.WriteFile(segmentPath(storePath, 1), original[1], 0666)
+	os.WriteFile(segmentPath(storePath, 2), original[2], 0666)
+	os.WriteFile(manifestPath(storePath), []byte("temp-1.db 1 2 3\n"), 0666)
+	check("after install")
+	if _, err := os.Stat(segmentPath(storePath, 1)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("merged inputs should be removed, got %v\n", err)
+	}
+
+	// crash before the output was put in place
+	for id, data := range original {
+		os.WriteFile(segmentPath(storePath, id), data, 0666)
+	}
+	os.WriteFile(path.Join(filepath.Dir(storePath), "temp-1.db"), merged, 0666)
+	os.WriteFile(manifestPath(storePath), []byte("temp-1.db 1 2 3\n"), 0666)
+	check("before install")
+	if data, _ := os.ReadFile(segmentPath(storePath, 1)); !slices.Equal(data, original[1]) {
+		t.Fatal("inputs of a merge that was rolled back should be untouched")
+	}
+}
+
+func TestDeadSpace(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	records := []Record{
+		{Key: []byte("akina-downhill"), Value: []byte("first")},
+		{Key: []byte("akina-downhill"), Value: []byte("second")},
+		{Key: []byte(OUTBOX_KEY), Value: []byte("[]")},
+		{Key: []byte(OUTBOX_KEY), Value: []byte("[{}]")},
+		{Key: []byte("usui-uphill"), Value: []byte("[]")},
+	}
+	for _, r := range records {
+		if err := store.Put(r); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	if err := store.Delete("usui-uphill"); err != nil {
+		t.Fatalf("error while deleting key: %v\n", err)
+	}
+
+	// every version is history except the replaced outbox and the deleted key
+	want := int64(recordSize(&records[2]) + recordSize(&records[4]))
+	if dead := store.active.dead; dead != want {
+		t.Fatalf("expected %d dead bytes, got %d\n", want, dead)
+	}
+	store.release()
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+	if dead := store.active.dead; dead != want {
+		t.Fatalf("expected %d dead bytes after reopening, got %d\n", want, dead)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	for _, key := range []string{"2025-1_akina-downhill", "2025-1_usui-uphill", "2025-10_akina-downhill", "akina-downhill"} {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte("[]")}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+
+	if err := store.Delete("akina-downhill"); err != nil {
+		t.Fatalf("error while deleting key: %v\n", err)
+	}
+	if err := store.Delete("akina-downhill"); err != ERR_KEY_NOT_FOUND {
+		t.Fatalf("deleting a missing key should fail, got %v\n", err)
+	}
+
+	n, err := store.DeletePrefix("2025-1_")
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 keys to be deleted, got %d: %v\n", n, err)
+	}
+
+	// tombstones are honored when the index is rebuilt
+	store.release()
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+
+	for _, key := range []string{"2025-1_akina-downhill", "2025-1_usui-uphill", "akina-downhill"} {
+		if _, err := store.Get(key); err != ERR_KEY_NOT_FOUND {
+			t.Fatalf("%s should be deleted, got %v\n", key, err)
+		}
+	}
+	if _, err := store.Get("2025-10_akina-downhill"); err != nil {
+		t.Fatalf("key outside of the prefix should be kept: %v\n", err)
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("error while compacting store: %v\n", err)
+	}
+	defer store.Close()
+
+	report, err := Verify(segmentPath(storePath, 1))
+	if err != nil || report.Records != 1 {
+		t.Fatalf("compaction should drop deleted keys and their tombstones, got %+v: %v\n", report, err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	store, err := open(path.Join(t.TempDir(), "kaido.store"), Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+	if err := store.Put(Record{Key: []byte("akina-downhill"), Value: []byte("[]")}); err != nil {
+		t.Fatalf("error while putting new record in the store: %v\n", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("error while closing store: %v\n", err)
+	}
+
+	if err := store.Put(Record{Key: []byte("akina-downhill"), Value: []byte("[]")}); err != ERR_CLOSED {
+		t.Fatalf("put after close should fail, got %v\n", err)
+	}
+	if _, err := store.Get("akina-downhill"); err != ERR_CLOSED {
+		t.Fatalf("get after close should fail, got %v\n", err)
+	}
+	if err := store.Delete("akina-downhill"); err != ERR_CLOSED {
+		t.Fatalf("delete after close should fail, got %v\n", err)
+	}
+	if err := store.Compact(); err != ERR_CLOSED {
+		t.Fatalf("compact after close should fail, got %v\n", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("closing twice should be a no-op, got %v\n", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	store := tempStore(t)
+
+	for _, key := range []string{"usui-uphill", "2025-3_akina-downhill", "akina-downhill", "2025-3_usui-uphill", "2025-4_akina-downhill"} {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte(key)}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+
+	keys := store.Keys()
+	if !slices.IsSorted(keys) || len(keys) != 5 {
+		t.Fatalf("expected 5 sorted keys, got %v\n", keys)
+	}
+
+	var scanned []string
+	for r, err := range store.Scan("2025-3_") {
+		if err != nil {
+			t.Fatalf("error while scanning: %v\n", err)
+		}
+		scanned = append(scanned, string(r.Value))
+	}
+	if !slices.Equal(scanned, []string{"2025-3_akina-downhill", "2025-3_usui-uphill"}) {
+		t.Fatalf("unexpected scan result: %v\n", scanned)
+	}
+
+	var ranged []string
+	for r, err := range store.Range("2025-4", "b") {
+		if err != nil {
+			t.Fatalf("error while ranging: %v\n", err)
+		}
+		ranged = append(ranged, string(r.Key))
+	}
+	if !slices.Equal(ranged, []string{"2025-4_akina-downhill", "akina-downhill"}) {
+		t.Fatalf("unexpected range result: %v\n", ranged)
+	}
+}
+
+func TestHintFile(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	for _, key := range []string{"akina-downhill", "usui-uphill", "myogi-downhill"} {
+		if err := store.Put(Record{Key: []byte(key), Value: []byte(key)}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatalf("error while compacting store: %v\n", err)
+	}
+	if _, err := os.Stat(hintPath(segmentPath(storePath, 1))); err != nil {
+		t.Fatalf("compaction should write a hint file: %v\n", err)
+	}
+
+	// changes after the compaction are not in the hint file and must be picked up by scanning
+	if err := store.Put(Record{Key: []byte("akina-downhill"), Value: []byte("updated")}); err != nil {
+		t.Fatalf("error while putting new record in the store: %v\n", err)
+	}
+	if err := store.Delete("usui-uphill"); err != nil {
+		t.Fatalf("error while deleting key: %v\n", err)
+	}
+	store.release()
+
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	if keys := store.Keys(); !slices.Equal(keys, []string{"akina-downhill", "myogi-downhill"}) {
+		t.Fatalf("unexpected keys after loading hints: %v\n", keys)
+	}
+	if rec, err := store.Get("akina-downhill"); err != nil || string(rec.Value) != "updated" {
+		t.Fatalf("expected the update after compaction, got %v\n", err)
+	}
+	store.release()
+
+	// a broken hint file falls back to a full scan
+	if err := os.WriteFile(hintPath(segmentPath(storePath, 1)), []byte("garbage"), 0666); err != nil {
+		t.Fatalf("error while overwriting hint file: %v\n", err)
+	}
+	store, err = open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+	if keys := store.Keys(); !slices.Equal(keys, []string{"akina-downhill", "myogi-downhill"}) {
+		t.Fatalf("unexpected keys after full scan: %v\n", keys)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	store, err := open(storePath, Options{MaxSegmentSize: 100})
+	if err != nil {
+		t.Fatalf("error while initializing store file: %v\n", err)
+	}
+
+	keys := []string{"akina-downhill", "usui-uphill", "myogi-downhill", "akagi-downhill"}
+	for _, value := range []string{"first", "second"} {
+		for _, key := range keys {
+			if err := store.Put(Record{Key: []byte(key), Value: []byte(value)}); err != nil {
+				t.Fatalf("error while putting new record in the store: %v\n", err)
+			}
+		}
+	}
+
+	segments, _ := Segments(storePath)
+	if len(segments) != 4 {
+		t.Fatalf("expected writes to roll over into 4 segments, got %v\n", segments)
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("error while compacting store: %v\n", err)
+	}
+	segments, _ = Segments(storePath)
+	if len(segments) != 2 {
+		t.Fatalf("expected sealed segments to be merged next to a new active one, got %v\n", segments)
+	}
+	if err := store.Put(Record{Key: []byte("akina-downhill"), Value: []byte("third")}); err != nil {
+		t.Fatalf("error while putting new record in the store: %v\n", err)
+	}
+	store.release()
+
+	store, err = open(storePath, Options{MaxSegmentSize: 100})
+	if err != nil {
+		t.Fatalf("error while reopening store file: %v\n", err)
+	}
+	defer store.Close()
+
+	for _, key := range keys {
+		want := "second"
+		if key == "akina-downhill" {
+			want = "third"
+		}
+		rec, err := store.Get(key)
+		if err != nil || string(rec.Value) != want {
+			t.Fatalf("expected %s to be %s after reopening, got %v\n", key, want, err)
+		}
+	}
+	if records, _ := store.History("akina-downhill"); len(records) != 3 {
+		t.Fatalf("history should span the merged and the active segment, got %d versions\n", len(records))
+	}
+}
+
+func TestMigrateSingleFileStore(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+	buf := append(fileHeader(), recTobuf(Record{Key: []byte("akina-downhill"), Value: []byte("[]")})...)
+	if err := os.WriteFile(storePath, buf, 0666); err != nil {
+		t.Fatalf("error while writing single file store: %v\n", err)
+	}
+
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while opening single file store: %v\n", err)
+	}
+	defer store.Close()
+
+	if _, err := store.Get("akina-downhill"); err != nil {
+		t.Fatalf("record of the single file store should be readable: %v\n", err)
+	}
+	if _, err := os.Stat(segmentPath(storePath, 1)); err != nil {
+		t.Fatalf("single file store should become the first segment: %v\n", err)
+	}
+}
+
+func TestLockWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	l, err := lockWorkspace(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("error while locking workspace: %v\n", err)
+	}
+
+	var locked *LockedError
+	if _, err := lockWorkspace(context.Background(), dir, false); !errors.As(err, &locked) || locked.PID != os.Getpid() {
+		t.Fatalf("expected the workspace to be locked by pid %d, got %v\n", os.Getpid(), err)
+	}
+
+	// waiting gives up once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), LOCK_POLL_INTERVAL/2)
+	defer cancel()
+	if _, err := lockWorkspace(ctx, dir, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected waiting for the lock to be cancelled, got %v\n", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		l, err := lockWorkspace(context.Background(), dir, true)
+		if err == nil {
+			l.release()
+		}
+		done <- err
+	}()
+	time.Sleep(LOCK_POLL_INTERVAL / 2)
+
+	if err := l.release(); err != nil {
+		t.Fatalf("error while releasing workspace lock: %v\n", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiting for the workspace lock should succeed once it is released: %v\n", err)
+		}
+	case <-time.After(5 * LOCK_POLL_INTERVAL):
+		t.Fatal("waiting for the workspace lock did not finish after it was released")
+	}
+}
+
+func TestMigrateV0Store(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "kaido.store")
+
+	// checksummed records with 32 bit timestamps in seconds and no file header
+	v0 := func(key, value string, tombstone bool) []byte {
+		buf := make([]byte, V0_HEADER_SIZE+len(key)+len(value))
+		binary.LittleEndian.PutUint32(buf[4:8], 1000)
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(len(key)))
+		binary.LittleEndian.PutUint32(buf[12:16], uint32(len(value)))
+		if tombstone {
+			binary.LittleEndian.PutUint32(buf[12:16], TOMBSTONE)
+		}
+		copy(buf[V0_HEADER_SIZE:], key)
+		copy(buf[V0_HEADER_SIZE+len(key):], value)
+		binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+		return buf
+	}
+	first := append(v0("akina-downhill", "[]", false), v0("usui-uphill", "[]", false)...)
+	if err := os.WriteFile(segmentPath(storePath, 1), first, 0666); err != nil {
+		t.Fatalf("error while writing v0 segment: %v\n", err)
+	}
+	if err := os.WriteFile(segmentPath(storePath, 2), v0("usui-uphill", "", true), 0666); err != nil {
+		t.Fatalf("error while writing v0 segment: %v\n", err)
+	}
+
+	store, err := open(storePath, Options{})
+	if err != nil {
+		t.Fatalf("error while opening v0 store: %v\n", err)
+	}
+	defer store.Close()
+
+	rec, err := store.Get("akina-downhill")
+	if err != nil || rec.Timestamp != time.Unix(1000, 0).UnixNano() {
+		t.Fatalf("v0 record should be readable with its timestamp in nanoseconds, got %v: %v\n", rec, err)
+	}
+	if _, err := store.Get("usui-uphill"); err != ERR_KEY_NOT_FOUND {
+		t.Fatalf("v0 tombstones should survive the migration, got %v\n", err)
+	}
+
+	for _, id := range []int{1, 2} {
+		report, err := Verify(segmentPath(storePath, id))
+		if err != nil || !report.Ok() || report.Legacy || report.Version != FORMAT_VERSION {
+			t.Fatalf("segment %d should be upgraded to version %d, got %+v: %v\n", id, FORMAT_VERSION, report, err)
+		}
+	}
+}
+
+func TestBackends(t *testing.T) {
+	backends := map[string]Store{
+		BACKEND_LOG:    tempStore(t),
+		BACKEND_MEMORY: NewMemoryStore(),
+	}
+
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			for i, key := range []string{"2025-3_akina-downhill", "2025-3_usui-uphill", "akina-downhill", "akina-downhill"} {
+				if err := store.Put(Record{Timestamp: int64(i), Key: []byte(key), Value: []byte(key)}); err != nil {
+					t.Fatalf("error while putting new record in the store: %v\n", err)
+				}
+			}
+
+			if records, err := store.History("akina-downhill"); err != nil || len(records) != 2 {
+				t.Fatalf("expected 2 versions of akina-downhill, got %d: %v\n", len(records), err)
+			}
+			if rec, err := At(store, "akina-downhill", time.Unix(0, 2)); err != nil || rec.Timestamp != 2 {
+				t.Fatalf("expected the version written at 2, got %v: %v\n", rec, err)
+			}
+
+			if err := store.Delete("akina-downhill"); err != nil {
+				t.Fatalf("error while deleting key: %v\n", err)
+			}
+			if _, err := store.Get("akina-downhill"); err != ERR_KEY_NOT_FOUND {
+				t.Fatalf("deleted key should not be found, got %v\n", err)
+			}
+
+			var scanned []string
+			for r, err := range store.Scan("2025-3_") {
+				if err != nil {
+					t.Fatalf("error while scanning: %v\n", err)
+				}
+				scanned = append(scanned, string(r.Key))
+			}
+			if !slices.Equal(scanned, []string{"2025-3_akina-downhill", "2025-3_usui-uphill"}) {
+				t.Fatalf("unexpected scan result: %v\n", scanned)
+			}
+
+			if n, err := store.DeletePrefix("2025-3_"); err != nil || n != 2 {
+				t.Fatalf("expected 2 keys to be deleted, got %d: %v\n", n, err)
+			}
+			if keys := store.Keys(); len(keys) != 0 {
+				t.Fatalf("expected no keys left, got %v\n", keys)
+			}
+		})
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	root := NewMemoryStore()
+	staging := Namespace(root, "staging")
+
+	for _, s := range []Store{root, staging} {
+		if err := s.Put(Record{Timestamp: 1, Key: []byte("akina-downhill"), Value: []byte("1")}); err != nil {
+			t.Fatalf("error while putting new record in the store: %v\n", err)
+		}
+	}
+	if err := Enqueue(staging, "event", []byte("{}")); err != nil {
+		t.Fatalf("error while enqueueing: %v\n", err)
+	}
+
+	if keys := root.Keys(); !slices.Equal(keys, []string{"@staging/__outbox", "@staging/akina-downhill", "akina-downhill"}) {
+		t.Fatalf("unexpected keys in the root of the store: %v\n", keys)
+	}
+	if keys := staging.Keys(); !slices.Equal(keys, []string{"__outbox", "akina-downhill"}) {
+		t.Fatalf("unexpected keys in the namespace: %v\n", keys)
+	}
+	if r, err := staging.Get("akina-downhill"); err != nil || string(r.Key) != "akina-downhill" {
+		t.Fatalf("namespace should hand out keys without the namespace, got %v: %v\n", r, err)
+	}
+	if pending, err := Pending(root); err != nil || len(pending) != 0 {
+		t.Fatalf("outbox of the namespace should not show in the root, got %v: %v\n", pending, err)
+	}
+
+	if n, err := staging.DeletePrefix(""); err != nil || n != 2 {
+		t.Fatalf("expected 2 keys deleted, got %d: %v\n", n, err)
+	}
+	if _, err := root.Get("akina-downhill"); err != nil {
+		t.Fatalf("deleting the namespace should not touch the root: %v\n", err)
+	}
+
+	if namespace, key := SplitNamespace("@staging/2025-3_akina-downhill"); namespace != "staging" || key != "2025-3_akina-downhill" {
+		t.Fatalf("unexpected split %s %s\n", namespace, key)
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"os"
+)
+
+// Report describes the integrity of a segment file.
+type Report struct {
+	Path    string
+	Size    int64
+	Records int
+	Keys    int
+	// Valid is how many bytes from the start of the file can be read back
+	Valid int64
+	// Corrupt is how many records before Valid fail their checksum, they are skipped on open
+	Corrupt int
+	// Version is the format version of the file, 0 for files written before the format was versioned
+	Version int
+	// Legacy files are readable but are upgraded to the current format on open
+	Legacy bool
+	Err    error
+}
+
+func (r *Report) Ok() bool {
+	return r.Err == nil && r.Corrupt == 0
+}
+
+// Verify scans the segment file at path without modifying it.
+func Verify(path string) (*Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Path: path,
+		Size: info.Size(),
+	}
+	if report.Size == 0 {
+		return report, nil
+	}
+
+	keys := make(map[string]bool)
+	count := func(offset int64, r *Record) {
+		report.Records++
+		keys[string(r.Key)] = true
+	}
+
+	ft, err := fileFormat(file, report.Size)
+	if err != nil {
+		report.Err = err
+		return report, nil
+	}
+
+	skip := func(offset, length int64) {
+		report.Corrupt++
+	}
+
+	if ft == formatV1 {
+		report.Version = FORMAT_VERSION
+		report.Valid, report.Err = scan(file, FILE_HEADER_SIZE, report.Size, formatV1, count, skip)
+		report.Keys = len(keys)
+		return report, nil
+	}
+
+	// records without checksums are not corrupt, they only have an even older layout
+	report.Legacy = true
+	report.Valid, report.Err = scan(file, 0, report.Size, unversionedFormat(file, report.Size), count, skip)
+	report.Keys = len(keys)
+
+	return report, nil
+}